@@ -10,33 +10,136 @@ type ListenerT struct {
 	Address string `yaml:"address"`
 }
 
+// HealthCheckFlapDetectionT holds out of the hashring the backends changing their health too often
+type HealthCheckFlapDetectionT struct {
+	Transitions int           `yaml:"transitions"`
+	Window      time.Duration `yaml:"window"`
+	StableTime  time.Duration `yaml:"stable_time"`
+}
+
 type HealthCheckT struct {
-	Timeout time.Duration `yaml:"timeout"`
-	Retries int           `yaml:"retries"`
-	Path    string        `yaml:"path"`
+	Type     string        `yaml:"type,omitempty"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Retries  int           `yaml:"retries"`
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// Request and expected response of the HTTP checks
+	Host               string            `yaml:"host,omitempty"`
+	Headers            map[string]string `yaml:"headers,omitempty"`
+	InsecureSkipVerify bool              `yaml:"insecure_skip_verify,omitempty"`
+	ExpectedStatuses   []string          `yaml:"expected_statuses,omitempty"`
+	ExpectedBody       string            `yaml:"expected_body,omitempty"`
+	ExpectedBodyRegex  string            `yaml:"expected_body_regex,omitempty"`
+
+	//
+	HealthyThreshold   int                       `yaml:"healthy_threshold,omitempty"`
+	UnhealthyThreshold int                       `yaml:"unhealthy_threshold,omitempty"`
+	MinTimeInState     time.Duration             `yaml:"min_time_in_state,omitempty"`
+	FlapDetection      HealthCheckFlapDetectionT `yaml:"flap_detection,omitempty"`
 }
 
 type BackendsStaticT struct {
 	Name        string       `yaml:"name"`
 	Host        string       `yaml:"host"`
+	Weight      int          `yaml:"weight,omitempty"`
 	HealthCheck HealthCheckT `yaml:"healthcheck,omitempty"`
 }
 
+// BackendsDnsWeightT assigns a weight to the DNS-discovered backends
+// whose IP matches the given address, expressed as an IP or a CIDR
+type BackendsDnsWeightT struct {
+	Address string `yaml:"address"`
+	Weight  int    `yaml:"weight"`
+}
+
 type BackendsDnsT struct {
-	Name        string       `yaml:"name"`
-	Domain      string       `yaml:"domain"`
-	Port        int          `yaml:"port"`
-	HealthCheck HealthCheckT `yaml:"healthcheck,omitempty"`
+	Type            string               `yaml:"type,omitempty"`
+	Name            string               `yaml:"name"`
+	Domain          string               `yaml:"domain"`
+	Port            int                  `yaml:"port"`
+	Weights         []BackendsDnsWeightT `yaml:"weights,omitempty"`
+	SlotGracePeriod time.Duration        `yaml:"slot_grace_period,omitempty"`
+	HealthCheck     HealthCheckT         `yaml:"healthcheck,omitempty"`
 }
 
 type BackendsT struct {
-	Synchronization string            `yaml:"synchronization"`
-	Static          []BackendsStaticT `yaml:"static,omitempty"`
-	Dns             BackendsDnsT      `yaml:"dns,omitempty"`
+	Synchronization        string            `yaml:"synchronization"`
+	HealthCheckConcurrency int               `yaml:"healthcheck_concurrency,omitempty"`
+	Static                 []BackendsStaticT `yaml:"static,omitempty"`
+	Dns                    BackendsDnsT      `yaml:"dns,omitempty"`
+}
+
+// HashKeyTransformT represents a transformation applied to the hash key after expanding its pattern.
+// When the regex matches the key, it is replaced by the expansion of the replacement ($1, ${name}...)
+type HashKeyTransformT struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement,omitempty"`
+}
+
+// HashKeyNormalizeT represents the normalizations applied to the hash key once its tags are expanded
+type HashKeyNormalizeT struct {
+	Lowercase          bool     `yaml:"lowercase,omitempty"`
+	PercentDecode      bool     `yaml:"percent_decode,omitempty"`
+	CollapseSlashes    bool     `yaml:"collapse_slashes,omitempty"`
+	StripTrailingSlash bool     `yaml:"strip_trailing_slash,omitempty"`
+	SortQuery          bool     `yaml:"sort_query,omitempty"`
+	QueryAllowlist     []string `yaml:"query_allowlist,omitempty"`
+	QueryDenylist      []string `yaml:"query_denylist,omitempty"`
+}
+
+// HashKeyOnEmptyT represents what to do with requests whose hash key is empty for every pattern
+type HashKeyOnEmptyT struct {
+	Action     string `yaml:"action"`
+	Backend    string `yaml:"backend,omitempty"`
+	StatusCode int    `yaml:"status_code,omitempty"`
 }
 
 type HashKeyT struct {
-	Pattern string `yaml:"pattern"`
+	Pattern      string              `yaml:"pattern"`
+	Patterns     []string            `yaml:"patterns,omitempty"`
+	OnEmpty      HashKeyOnEmptyT     `yaml:"on_empty,omitempty"`
+	Normalize    HashKeyNormalizeT   `yaml:"normalize,omitempty"`
+	Transforms   []HashKeyTransformT `yaml:"transforms,omitempty"`
+	Algorithm    string              `yaml:"algorithm,omitempty"`
+	HashFunction string              `yaml:"hash_function,omitempty"`
+}
+
+// TlsCertificateT represents a certificate and its key, as paths to PEM files
+type TlsCertificateT struct {
+	Certificate string `yaml:"certificate"`
+	Key         string `yaml:"key"`
+}
+
+// RetryPolicyT represents the conditions to retry a request on another backend, and how
+type RetryPolicyT struct {
+	RetryOn             []string      `yaml:"retry_on,omitempty"`
+	StatusCodes         []int         `yaml:"status_codes,omitempty"`
+	Headers             []string      `yaml:"headers,omitempty"`
+	MaxAttempts         int           `yaml:"max_attempts,omitempty"`
+	PerTryTimeout       time.Duration `yaml:"per_try_timeout,omitempty"`
+	BackoffBaseInterval time.Duration `yaml:"backoff_base_interval,omitempty"`
+	BackoffMaxInterval  time.Duration `yaml:"backoff_max_interval,omitempty"`
+}
+
+// OutlierDetectionT represents the conditions to eject a backend from the selection,
+// based on the results of the requests sent to it
+type OutlierDetectionT struct {
+	ConsecutiveErrors  int           `yaml:"consecutive_errors,omitempty"`
+	BaseEjectionTime   time.Duration `yaml:"base_ejection_time,omitempty"`
+	MaxEjectionTime    time.Duration `yaml:"max_ejection_time,omitempty"`
+	MaxEjectionPercent int           `yaml:"max_ejection_percent,omitempty"`
+}
+
+// CircuitBreakerT represents the conditions to stop sending requests to a backend for a while,
+// based on the error rate and latency of the requests sent to it over a rolling window
+type CircuitBreakerT struct {
+	Window              time.Duration `yaml:"window,omitempty"`
+	MinimumRequests     int           `yaml:"minimum_requests,omitempty"`
+	ErrorRateThreshold  float64       `yaml:"error_rate_threshold,omitempty"`
+	LatencyThreshold    time.Duration `yaml:"latency_threshold,omitempty"`
+	OpenTime            time.Duration `yaml:"open_time,omitempty"`
+	HalfOpenMaxRequests int           `yaml:"half_open_max_requests,omitempty"`
 }
 
 // OptionsT defines TODO
@@ -45,19 +148,65 @@ type OptionsT struct {
 	TlsCertificate string `yaml:"tls_certificate,omitempty"`
 	TlsKey         string `yaml:"tls_key,omitempty"`
 
+	// Additional certificates, selected by SNI
+	TlsCertificates []TlsCertificateT `yaml:"tls_certificates,omitempty"`
+
+	//
+	TlsMinVersion     string        `yaml:"tls_min_version,omitempty"`
+	TlsCipherSuites   []string      `yaml:"tls_cipher_suites,omitempty"`
+	TlsClientCa       string        `yaml:"tls_client_ca,omitempty"`
+	TlsClientAuth     string        `yaml:"tls_client_auth,omitempty"`
+	TlsReloadInterval time.Duration `yaml:"tls_reload_interval,omitempty"`
+
 	//
 	HttpServerReadTimeoutMillis  int  `yaml:"http_server_read_timeout_ms,omitempty"`
 	HttpServerWriteTimeoutMillis int  `yaml:"http_server_write_timeout_ms,omitempty"`
 	HttpServerDisableKeepAlives  bool `yaml:"http_server_disable_keep_alives,omitempty"`
 
 	//
-	HttpBackendDialTimeoutMillis    int  `yaml:"http_backend_dial_timeout_ms,omitempty"`
-	HttpBackendKeepAliveMillis      int  `yaml:"http_backend_keep_alive_ms,omitempty"`
-	HttpBackendRequestTimeoutMillis int  `yaml:"http_backend_request_timeout_ms,omitempty"`
-	HttpBackendDisableKeepAlives    bool `yaml:"http_backend_disable_keep_alives,omitempty"`
+	HttpBackendDialTimeoutMillis    int   `yaml:"http_backend_dial_timeout_ms,omitempty"`
+	HttpBackendKeepAliveMillis      int   `yaml:"http_backend_keep_alive_ms,omitempty"`
+	HttpBackendRequestTimeoutMillis int   `yaml:"http_backend_request_timeout_ms,omitempty"`
+	HttpBackendDisableKeepAlives    *bool `yaml:"http_backend_disable_keep_alives,omitempty"`
+
+	//
+	HttpBackendMaxIdleConnsPerBackend      int `yaml:"http_backend_max_idle_conns_per_backend,omitempty"`
+	HttpBackendMaxConnsPerBackend          int `yaml:"http_backend_max_conns_per_backend,omitempty"`
+	HttpBackendIdleConnTimeoutMillis       int `yaml:"http_backend_idle_conn_timeout_ms,omitempty"`
+	HttpBackendResponseHeaderTimeoutMillis int `yaml:"http_backend_response_header_timeout_ms,omitempty"`
+
+	// Protocol spoken to the backends: http1, h2 (over TLS) or h2c (cleartext)
+	HttpBackendProtocol              string `yaml:"http_backend_protocol,omitempty"`
+	HttpBackendTlsInsecureSkipVerify *bool  `yaml:"http_backend_tls_insecure_skip_verify,omitempty"`
+
+	// Flags are pointers, so routes can disable those enabled at proxy level
+	TryAnotherBackendOnFailure *bool `yaml:"try_another_backend_on_failure,omitempty"`
+
+	// Request bodies are buffered to be sent again on retries
+	RetryBodyBufferMemoryBytes        int64 `yaml:"retry_body_buffer_memory_bytes,omitempty"`
+	RetryBodyBufferMaxBytes           int64 `yaml:"retry_body_buffer_max_bytes,omitempty"`
+	RetryNonIdempotentOnConnectErrors *bool `yaml:"retry_non_idempotent_on_connect_errors,omitempty"`
+
+	//
+	RetryPolicy RetryPolicyT `yaml:"retry_policy,omitempty"`
 
 	//
-	TryAnotherBackendOnFailure bool `yaml:"try_another_backend_on_failure,omitempty"`
+	OutlierDetection OutlierDetectionT `yaml:"outlier_detection,omitempty"`
+	CircuitBreaker   CircuitBreakerT   `yaml:"circuit_breaker,omitempty"`
+
+	// Host header sent to the backends: preserve, backend or fixed (sending HostHeaderValue)
+	HostHeader      string `yaml:"host_header,omitempty"`
+	HostHeaderValue string `yaml:"host_header_value,omitempty"`
+
+	// Pseudonym of the proxy in the 'Via' header
+	ViaPseudonym     string `yaml:"via_pseudonym,omitempty"`
+	DisableViaHeader *bool  `yaml:"disable_via_header,omitempty"`
+
+	// Proxies (IPs or CIDRs) allowed to set the client IP through the X-Forwarded-For header
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+
+	//
+	BoundedLoadsEpsilon float64 `yaml:"bounded_loads_epsilon,omitempty"`
 }
 
 // LogsT TODO
@@ -73,11 +222,39 @@ type CommonT struct {
 	Logs LogsT `yaml:"logs"`
 }
 
+// RouteMatchHeaderT matches a request header by its exact value or by a regex.
+// When none of them is set, the header only needs to be present
+type RouteMatchHeaderT struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value,omitempty"`
+	Regex string `yaml:"regex,omitempty"`
+}
+
+// RouteMatchT represents the conditions a request must meet to be served by a route.
+// All the defined conditions must be met. Empty conditions match any request
+type RouteMatchT struct {
+	Hosts      []string            `yaml:"hosts,omitempty"`
+	PathPrefix string              `yaml:"path_prefix,omitempty"`
+	PathRegex  string              `yaml:"path_regex,omitempty"`
+	Methods    []string            `yaml:"methods,omitempty"`
+	Headers    []RouteMatchHeaderT `yaml:"headers,omitempty"`
+}
+
+// RouteT represents a set of backends serving the requests matching some conditions
+type RouteT struct {
+	Name     string      `yaml:"name"`
+	Match    RouteMatchT `yaml:"match,omitempty"`
+	Backends BackendsT   `yaml:"backends"`
+	HashKey  HashKeyT    `yaml:"hash_key,omitempty"`
+	Options  OptionsT    `yaml:"options,omitempty"`
+}
+
 // ProxyT TODO
 type ProxyT struct {
 	Name     string    `yaml:"name"`
 	Listener ListenerT `yaml:"listener"`
-	Backends BackendsT `yaml:"backends"`
+	Routes   []RouteT  `yaml:"routes,omitempty"`
+	Backends BackendsT `yaml:"backends,omitempty"`
 	HashKey  HashKeyT  `yaml:"hash_key"`
 	Options  OptionsT  `yaml:"options"`
 }
@@ -10,7 +10,6 @@ import (
 	"hashrouter/internal/metrics"
 	"hashrouter/internal/proxy"
 	"log"
-	"reflect"
 	"sync"
 	"time"
 
@@ -122,26 +121,23 @@ func RunCommand(cmd *cobra.Command, args []string) {
 		// This will allow access to its properties everywhere
 		globals.Application.ProxyPool[proxyConfig.Name] = proxyObj
 
-		if reflect.ValueOf(proxyObj.SelfConfig.Backends.Dns).IsZero() && reflect.ValueOf(proxyObj.SelfConfig.Backends.Static).IsZero() {
-			logger.Errorf("backends not defined for proxy '%s'", proxyObj.SelfConfig.Name)
-			continue
-		}
-
-		if !reflect.ValueOf(proxyObj.SelfConfig.Backends.Dns).IsZero() && !reflect.ValueOf(proxyObj.SelfConfig.Backends.Static).IsZero() {
-			logger.Errorf("failed to load backends: static and dns are mutually exclusive for proxy '%s'",
-				proxyObj.SelfConfig.Name)
+		err = proxyObj.BuildRoutes()
+		if err != nil {
+			logger.Errorf("error building routes for proxy '%s': %s", proxyObj.SelfConfig.Name, err.Error())
 			continue
 		}
 
-		syncTime, err := time.ParseDuration(proxyObj.SelfConfig.Backends.Synchronization)
+		err = proxyObj.BuildTlsConfig()
 		if err != nil {
-			logger.Errorf("error parsing backend synchronization time for proxy '%s': %s",
-				proxyObj.SelfConfig.Name, err.Error())
+			logger.Errorf("error building TLS configuration for proxy '%s': %s", proxyObj.SelfConfig.Name, err.Error())
 			continue
 		}
 
 		waitGroup.Add(1)
-		go proxyObj.Synchronizer(syncTime)
+		go proxyObj.WatchTlsCertificates()
+		for _, route := range proxyObj.Routes {
+			go route.Synchronizer()
+		}
 		go proxyObj.Run(&waitGroup)
 
 		time.Sleep(2 * time.Second) // TODO: unhardcode this
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+
+	// Hash functions available to place servers and keys on the ring
+	HashFunctionCrc32    = "crc32"
+	HashFunctionFnv1a    = "fnv1a"
+	HashFunctionMurmur3  = "murmur3"
+	HashFunctionXxhash64 = "xxhash64"
+)
+
+// Hasher represents a hash function returning positions in the 64 bits space of the ring
+type Hasher interface {
+	Sum64(data []byte) uint64
+}
+
+// NewHasher returns the Hasher for the given hash function.
+// An empty name selects the default one: 'crc32'. It is the default to keep the placements
+// of the rings created before the hash function was configurable
+func NewHasher(hashFunction string) (Hasher, error) {
+	switch hashFunction {
+	case HashFunctionCrc32, "":
+		return crc32Hasher{}, nil
+	case HashFunctionFnv1a:
+		return fnv1aHasher{}, nil
+	case HashFunctionMurmur3:
+		return murmur3Hasher{}, nil
+	case HashFunctionXxhash64:
+		return xxhash64Hasher{}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash function '%s'", hashFunction)
+	}
+}
+
+// crc32Hasher implements CRC32 (IEEE). Only the lower 32 bits of the positions are used
+type crc32Hasher struct{}
+
+func (crc32Hasher) Sum64(data []byte) uint64 {
+	return uint64(crc32.ChecksumIEEE(data))
+}
+
+// fnv1aHasher implements 64 bits FNV-1a
+type fnv1aHasher struct{}
+
+func (fnv1aHasher) Sum64(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// xxhash64Hasher implements XXH64 with seed 0
+type xxhash64Hasher struct{}
+
+func (xxhash64Hasher) Sum64(data []byte) uint64 {
+	return xxhash.Sum64(data)
+}
+
+// murmur3Hasher implements MurmurHash3 x64 128 bits with seed 0, returning the first 64 bits
+// Ref: https://github.com/aappleby/smhasher/blob/master/src/MurmurHash3.cpp
+type murmur3Hasher struct{}
+
+func (murmur3Hasher) Sum64(data []byte) uint64 {
+	const (
+		c1 = 0x87c37b91114253d5
+		c2 = 0x4cf5ad432745937f
+	)
+
+	var h1, h2 uint64
+	length := len(data)
+
+	// Body
+	for len(data) >= 16 {
+		k1 := binary.LittleEndian.Uint64(data[0:8])
+		k2 := binary.LittleEndian.Uint64(data[8:16])
+		data = data[16:]
+
+		k1 *= c1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= c2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	// Tail
+	var k1, k2 uint64
+	for i := len(data) - 1; i >= 8; i-- {
+		k2 ^= uint64(data[i]) << (uint(i-8) * 8)
+	}
+	if len(data) > 8 {
+		k2 *= c2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+	}
+
+	for i := min(len(data), 8) - 1; i >= 0; i-- {
+		k1 ^= uint64(data[i]) << (uint(i) * 8)
+	}
+	if len(data) > 0 {
+		k1 *= c1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+	}
+
+	// Finalization
+	h1 ^= uint64(length)
+	h2 ^= uint64(length)
+
+	h1 += h2
+	h2 += h1
+
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+
+	h1 += h2
+
+	return h1
+}
+
+// mixedHasher scrambles the positions returned by the wrapped Hasher with mix64
+type mixedHasher struct {
+	hasher Hasher
+}
+
+func (h mixedHasher) Sum64(data []byte) uint64 {
+	return mix64(h.hasher.Sum64(data))
+}
+
+// fmix64 is the finalization mix of MurmurHash3
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// mix64 scrambles the bits of the given value (SplitMix64 finalizer).
+// It is used to combine two hashes, and to spread the hashes of functions
+// that do not use the whole 64 bits space, such as CRC32
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -5,112 +5,329 @@ package hashring
 
 import (
 	"fmt"
-	"hash/crc32"
+	"maps"
 	"slices"
-	"sort"
-	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
+const (
+
+	// Algorithms available to place servers and keys
+	AlgorithmVnode      = "vnode"
+	AlgorithmRendezvous = "rendezvous"
+	AlgorithmJump       = "jump"
+	AlgorithmMaglev     = "maglev"
+	AlgorithmKetama     = "ketama"
+	AlgorithmNginx      = "nginx"
+
+	// Amount of virtual nodes created for each server in 'vnode' mode.
+	// It is multiplied by the weight of the server, once the weights are reduced by their GCD
+	defaultVnodesPerNode = 1000
+
+	// Maximum amount of virtual nodes of the whole ring in 'vnode' mode.
+	// Servers get less virtual nodes each when the weights would exceed it
+	maxVnodes = 100000
+
+	// Weight assigned to the servers added without a positive weight
+	DefaultWeight = 1
+
+	// Maximum weight of a server. Higher weights are lowered to it
+	MaxWeight = 1000
+)
+
+// Balancer represents an algorithm able to select servers for a given key.
+// Balancers are built for a fixed set of servers and never modified later,
+// so they are safe for concurrent use
+type Balancer interface {
+
+	// Walk visits the servers in the order of preference of the algorithm for the given key,
+	// visiting each server once, until 'visit' returns false
+	Walk(key string, visit func(server string) bool)
+}
+
+// Member represents a server of the ring.
+// Servers are placed by their name, which is their stable identity,
+// while the address is where they are reachable and can change over time
+type Member struct {
+	Name    string
+	Address string
+	Weight  int
+}
+
+// BalancerBuilder builds a Balancer for the given servers.
+// Servers are sorted and every one of them has a positive weight
+type BalancerBuilder func(servers []string, weights map[string]int) Balancer
+
+// HashRing holds the current Snapshot of the ring.
+// Readers get the snapshot without locking, while membership updates
+// build a new snapshot and swap it atomically
 type HashRing struct {
-	sync.RWMutex
+
+	// Serializes the membership updates. Readers never take it
+	sync.Mutex
 
 	//
-	nodes         []Node
-	vnodesPerNode int
+	buildBalancer BalancerBuilder
+	snapshot      atomic.Pointer[Snapshot]
 }
 
-type Node struct {
-	hash   int
-	server string
+// Snapshot is an immutable view of the ring for a specific membership.
+// Resolving every server of a request against the same snapshot guarantees consistent results,
+// even when the membership is being updated at the same time
+type Snapshot struct {
+	balancer    Balancer
+	servers     []string
+	weights     map[string]int
+	totalWeight int
+	addresses   map[string]string
 }
 
-func NewHashRing(vnodesPerNode int) *HashRing {
-	return &HashRing{
-		vnodesPerNode: vnodesPerNode,
+// NewHashRing returns a HashRing whose placement is performed by the given algorithm,
+// hashing servers and keys with the given hash function.
+// An empty algorithm selects the default one: 'vnode'
+// An empty hash function selects the default one: 'crc32'
+func NewHashRing(algorithm string, hashFunction string) (*HashRing, error) {
+
+	hasher, err := NewHasher(hashFunction)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (h *HashRing) AddServer(server string) {
-	h.Lock()
-	defer h.Unlock()
+	var buildBalancer BalancerBuilder
 
-	//
-	for i := 0; i < h.vnodesPerNode; i++ {
-		vnode := server + "#" + strconv.Itoa(i)
-		hash := int(crc32.ChecksumIEEE([]byte(vnode)))
-		h.nodes = append(h.nodes, Node{hash: hash, server: server})
+	switch algorithm {
+	case AlgorithmVnode, "":
+		buildBalancer = func(servers []string, weights map[string]int) Balancer {
+			return newVnodeBalancer(servers, weights, hasher, defaultVnodesPerNode)
+		}
+	case AlgorithmRendezvous:
+		buildBalancer = func(servers []string, weights map[string]int) Balancer {
+			return newRendezvousBalancer(servers, weights, hasher)
+		}
+	case AlgorithmJump:
+		buildBalancer = func(servers []string, weights map[string]int) Balancer {
+			return newJumpBalancer(servers, weights, hasher)
+		}
+	case AlgorithmMaglev:
+		buildBalancer = func(servers []string, weights map[string]int) Balancer {
+			return newMaglevBalancer(servers, weights, hasher, defaultMaglevTableSize)
+		}
+
+	// Compatibility modes hash servers and keys exactly like the original implementations,
+	// so they can not work with a different hash function
+	case AlgorithmKetama, AlgorithmNginx:
+		if hashFunction != "" {
+			return nil, fmt.Errorf("hashring algorithm '%s' does not support setting a hash function", algorithm)
+		}
+
+		buildBalancer = func(servers []string, weights map[string]int) Balancer {
+			if algorithm == AlgorithmKetama {
+				return newKetamaBalancer(servers, weights)
+			}
+			return newNginxBalancer(servers, weights)
+		}
+	default:
+		return nil, fmt.Errorf("unknown hashring algorithm '%s'", algorithm)
+	}
+
+	h := &HashRing{
+		buildBalancer: buildBalancer,
 	}
-	sort.Slice(h.nodes, func(i, j int) bool {
-		return h.nodes[i].hash < h.nodes[j].hash
+	h.snapshot.Store(&Snapshot{
+		balancer:  buildBalancer(nil, nil),
+		weights:   make(map[string]int),
+		addresses: make(map[string]string),
 	})
+
+	return h, nil
 }
 
-func (h *HashRing) RemoveServer(server string) {
+// Snapshot returns the current view of the ring.
+// Requests should resolve all their servers against the same snapshot
+func (h *HashRing) Snapshot() *Snapshot {
+	return h.snapshot.Load()
+}
+
+// ApplyMembership builds a new snapshot with the members of 'add' included,
+// and the servers named in 'remove' excluded, then makes it the current one.
+// Members already present in the ring are updated with the new weight and address.
+// Weights lower than 1 are considered as DefaultWeight, higher than MaxWeight as MaxWeight,
+// and empty addresses as the name
+func (h *HashRing) ApplyMembership(add []Member, remove []string) {
 	h.Lock()
 	defer h.Unlock()
 
 	//
-	var newNodes []Node
-	for _, node := range h.nodes {
-		if node.server != server {
-			newNodes = append(newNodes, node)
+	current := h.snapshot.Load()
+
+	weights := maps.Clone(current.weights)
+	addresses := maps.Clone(current.addresses)
+	for _, member := range add {
+		if member.Weight < 1 {
+			member.Weight = DefaultWeight
 		}
+		member.Weight = min(member.Weight, MaxWeight)
+		if member.Address == "" {
+			member.Address = member.Name
+		}
+		weights[member.Name] = member.Weight
+		addresses[member.Name] = member.Address
+	}
+	for _, server := range remove {
+		delete(weights, server)
+		delete(addresses, server)
 	}
-	h.nodes = newNodes
-}
 
-func (h *HashRing) GetServer(key string) string {
-	h.RLock()
-	defer h.RUnlock()
+	if maps.Equal(weights, current.weights) && maps.Equal(addresses, current.addresses) {
+		return
+	}
 
-	//
-	if len(h.nodes) == 0 {
-		return ""
+	// Sorting is performed to ensure that the order of servers is always the same
+	// This will help to avoid unnecessary changes for the functions using this list
+	servers := make([]string, 0, len(weights))
+	totalWeight := 0
+	for server, weight := range weights {
+		servers = append(servers, server)
+		totalWeight += weight
 	}
-	hash := int(crc32.ChecksumIEEE([]byte(key)))
-	idx := sort.Search(len(h.nodes), func(i int) bool {
-		return h.nodes[i].hash >= hash
+	slices.Sort(servers)
+
+	h.snapshot.Store(&Snapshot{
+		balancer:    h.buildBalancer(servers, weights),
+		servers:     servers,
+		weights:     weights,
+		totalWeight: totalWeight,
+		addresses:   addresses,
 	})
-	if idx == len(h.nodes) {
-		idx = 0
-	}
-	return h.nodes[idx].server
 }
 
-// GetServerList returns the list of servers in the hash ring
+// AddServer adds a server to the ring, reachable at its own name.
+// Its share of keys is proportional to its weight
+func (h *HashRing) AddServer(server string, weight int) {
+	h.ApplyMembership([]Member{{Name: server, Address: server, Weight: weight}}, nil)
+}
+
+// RemoveServer removes a server from the ring
+func (h *HashRing) RemoveServer(server string) {
+	h.ApplyMembership(nil, []string{server})
+}
+
+// GetServer returns the server for the given key in the current snapshot
+func (h *HashRing) GetServer(key string) string {
+	return h.Snapshot().GetServer(key)
+}
+
+// GetServerList returns a copy of the list of servers in the current snapshot
 // This function is useful as servers can be defined by static configuration
 // or discovered by DNS
 func (h *HashRing) GetServerList() (servers []string) {
-	h.RLock()
-	defer h.RUnlock()
+	return slices.Clone(h.Snapshot().GetServerList())
+}
 
-	//
-	numRealNodes := len(h.nodes) / h.vnodesPerNode
+// GetServerWeights returns the weight of each server in the current snapshot
+func (h *HashRing) GetServerWeights() (weights map[string]int) {
+	return maps.Clone(h.Snapshot().weights)
+}
 
-	for _, nodeValue := range h.nodes {
+func (h *HashRing) String() string {
+	return h.Snapshot().String()
+}
+
+// GetServer returns the server the given key belongs to
+func (s *Snapshot) GetServer(key string) string {
+	if len(s.servers) == 0 {
+		return ""
+	}
 
-		if !slices.Contains(servers, nodeValue.server) {
-			servers = append(servers, nodeValue.server)
+	var server string
+	s.balancer.Walk(key, func(candidate string) bool {
+		server = candidate
+		return false
+	})
+	return server
+}
+
+// GetServers returns up to 'n' distinct servers for the given key in the order of preference of the ring:
+// the server the key belongs to, followed by the next ones walking clockwise from the key position.
+// This order is stable for each key, so the load of a missing server is spread across the whole ring
+func (s *Snapshot) GetServers(key string, n int) (servers []string) {
+	if n <= 0 || len(s.servers) == 0 {
+		return servers
+	}
+
+	servers = make([]string, 0, min(n, len(s.servers)))
+	s.balancer.Walk(key, func(candidate string) bool {
+		servers = append(servers, candidate)
+		return len(servers) < n
+	})
+	return servers
+}
+
+// Walk visits the servers in the order of preference of the ring for the given key,
+// visiting each server once, until 'visit' returns false
+func (s *Snapshot) Walk(key string, visit func(server string) bool) {
+	if len(s.servers) == 0 {
+		return
+	}
+	s.balancer.Walk(key, visit)
+}
+
+// GetBoundedServer returns the server for the given key, walking the ring to the next servers
+// while 'isOverloaded' reports the candidate as overloaded (consistent hashing with bounded loads).
+// When all the servers are overloaded, the server the key belongs to is returned.
+// The flag 'overflowed' reports whether the key was moved away from its natural server
+func (s *Snapshot) GetBoundedServer(key string, isOverloaded func(server string) bool) (server string, overflowed bool) {
+	if len(s.servers) == 0 {
+		return "", false
+	}
+
+	var primary string
+	s.balancer.Walk(key, func(candidate string) bool {
+		if primary == "" {
+			primary = candidate
 		}
 
-		if len(servers) == numRealNodes {
-			break
+		if isOverloaded(candidate) {
+			return true
 		}
+
+		server = candidate
+		return false
+	})
+
+	if server == "" {
+		return primary, false
 	}
+	return server, server != primary
+}
 
-	// Sorting is performed to ensure that the order of servers is always the same
-	// This will help to avoid unnecessary changes for the functions using this list
-	slices.Sort(servers)
+// GetServerList returns the sorted list of servers in the snapshot.
+// The returned slice is shared, so it must not be modified
+func (s *Snapshot) GetServerList() (servers []string) {
+	return s.servers
+}
 
-	return servers
+// GetServerWeight returns the weight of the given server, or 0 when it is not in the snapshot
+func (s *Snapshot) GetServerWeight(server string) int {
+	return s.weights[server]
 }
 
-func (h *HashRing) String() string {
-	servers := h.GetServerList()
+// GetTotalWeight returns the sum of the weights of all the servers in the snapshot
+func (s *Snapshot) GetTotalWeight() int {
+	return s.totalWeight
+}
+
+// GetServerAddress returns the address where the given server is reachable,
+// or an empty string when it is not in the snapshot
+func (s *Snapshot) GetServerAddress(server string) string {
+	return s.addresses[server]
+}
+
+func (s *Snapshot) String() string {
 	str := "{"
-	for _, v := range servers {
-		str += fmt.Sprintf("[host: '%s']", v)
+	for _, v := range s.servers {
+		str += fmt.Sprintf("[name: '%s', host: '%s', weight: '%d']", v, s.addresses[v], s.weights[v])
 	}
 	str += "}"
 	return str
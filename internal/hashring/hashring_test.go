@@ -0,0 +1,263 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+const (
+
+	// Amount of keys routed by the tests checking the placement of the keys
+	testNumKeys = 100000
+)
+
+// newTestRing returns a ring of the given algorithm with the given members, failing the test on error
+func newTestRing(t *testing.T, algorithm string, membership []Member) *HashRing {
+	t.Helper()
+
+	ring, err := NewHashRing(algorithm, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.ApplyMembership(membership, nil)
+
+	return ring
+}
+
+// newTestMembers returns 'n' members with weight 1, named as 'host:port'
+func newTestMembers(n int) (membership []Member) {
+	for i := 0; i < n; i++ {
+		membership = append(membership, Member{Name: fmt.Sprintf("10.0.0.%d:80", i+1), Weight: 1})
+	}
+	return membership
+}
+
+// getPlacements returns the server of each one of the test keys
+func getPlacements(ring *HashRing) []string {
+	snapshot := ring.Snapshot()
+
+	placements := make([]string, testNumKeys)
+	for i := range placements {
+		placements[i] = snapshot.GetServer(fmt.Sprintf("/assets/%d.js", i))
+	}
+	return placements
+}
+
+// TestWeightedSpread checks that every algorithm assigns the keys proportionally to the weights
+func TestWeightedSpread(t *testing.T) {
+	membership := []Member{
+		{Name: "10.0.0.1:80", Weight: 1},
+		{Name: "10.0.0.2:80", Weight: 2},
+		{Name: "10.0.0.3:80", Weight: 3},
+		{Name: "10.0.0.4:80", Weight: 4},
+	}
+
+	tests := []struct {
+		algorithm string
+		tolerance float64
+	}{
+		// CRC32 positions of similar vnode names are correlated, which skews the spread a bit
+		{algorithm: AlgorithmVnode, tolerance: 0.25},
+		{algorithm: AlgorithmRendezvous, tolerance: 0.05},
+		{algorithm: AlgorithmJump, tolerance: 0.05},
+		{algorithm: AlgorithmMaglev, tolerance: 0.05},
+	}
+
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			ring := newTestRing(t, test.algorithm, membership)
+
+			counts := map[string]int{}
+			for _, server := range getPlacements(ring) {
+				counts[server]++
+			}
+
+			for _, member := range membership {
+				fairShare := float64(testNumKeys) * float64(member.Weight) / 10
+				if ratio := float64(counts[member.Name]) / fairShare; ratio < 1-test.tolerance || ratio > 1+test.tolerance {
+					t.Errorf("server '%s' with weight %d got %.3f times its fair share", member.Name, member.Weight, ratio)
+				}
+			}
+		})
+	}
+}
+
+// TestServerRemoval checks that removing one of the servers mostly moves the keys it owned
+func TestServerRemoval(t *testing.T) {
+	const numServers = 10
+
+	tests := []struct {
+		algorithm string
+
+		// Maximum share of all the keys moved between the remaining servers
+		maxMoved float64
+	}{
+		{algorithm: AlgorithmVnode, maxMoved: 0},
+		{algorithm: AlgorithmRendezvous, maxMoved: 0},
+		{algorithm: AlgorithmMaglev, maxMoved: 0.02},
+	}
+
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			membership := newTestMembers(numServers)
+			ring := newTestRing(t, test.algorithm, membership)
+
+			removed := membership[numServers/2].Name
+			before := getPlacements(ring)
+			ring.RemoveServer(removed)
+			after := getPlacements(ring)
+
+			moved := 0
+			for i := range before {
+				if after[i] == removed {
+					t.Fatalf("key %d still routed to the removed server", i)
+				}
+				if before[i] != removed && before[i] != after[i] {
+					moved++
+				}
+			}
+
+			if share := float64(moved) / testNumKeys; share > test.maxMoved {
+				t.Errorf("%.4f of the keys moved between the remaining servers, expected at most %.4f", share, test.maxMoved)
+			}
+		})
+	}
+}
+
+// TestJumpPositional checks the positional behaviour of jump: removing the last server of the sorted list
+// only moves its own keys, while removing the first one moves many keys of the remaining servers
+func TestJumpPositional(t *testing.T) {
+	const numServers = 10
+
+	tests := []struct {
+		name     string
+		removed  int
+		minMoved float64
+		maxMoved float64
+	}{
+		{name: "last server", removed: numServers - 1, minMoved: 0, maxMoved: 0},
+		{name: "first server", removed: 0, minMoved: 0.3, maxMoved: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			membership := newTestMembers(numServers)
+			ring := newTestRing(t, AlgorithmJump, membership)
+
+			removed := ring.GetServerList()[test.removed]
+			before := getPlacements(ring)
+			ring.RemoveServer(removed)
+			after := getPlacements(ring)
+
+			moved := 0
+			for i := range before {
+				if before[i] != removed && before[i] != after[i] {
+					moved++
+				}
+			}
+
+			if share := float64(moved) / testNumKeys; share < test.minMoved || share > test.maxMoved {
+				t.Errorf("%.4f of the keys moved between the remaining servers, expected between %.2f and %.2f",
+					share, test.minMoved, test.maxMoved)
+			}
+		})
+	}
+}
+
+// TestWalk checks that every algorithm visits each server exactly once, and stops when asked to
+func TestWalk(t *testing.T) {
+	algorithms := []string{
+		AlgorithmVnode, AlgorithmRendezvous, AlgorithmJump, AlgorithmMaglev, AlgorithmKetama, AlgorithmNginx,
+	}
+
+	membership := newTestMembers(7)
+	membership[3].Weight = 5
+
+	for _, algorithm := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			snapshot := newTestRing(t, algorithm, membership).Snapshot()
+
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("user:%d", i)
+
+				visited := map[string]int{}
+				snapshot.Walk(key, func(server string) bool {
+					visited[server]++
+					return true
+				})
+
+				if len(visited) != len(membership) {
+					t.Fatalf("key '%s' visited %d servers, expected %d", key, len(visited), len(membership))
+				}
+				for server, visits := range visited {
+					if visits != 1 {
+						t.Fatalf("key '%s' visited server '%s' %d times", key, server, visits)
+					}
+				}
+
+				visits := 0
+				snapshot.Walk(key, func(server string) bool {
+					visits++
+					return visits < 3
+				})
+				if visits != 3 {
+					t.Fatalf("key '%s' kept visiting %d servers after stopping at 3", key, visits)
+				}
+			}
+		})
+	}
+}
+
+// TestGetServers checks that the fallback list of a key is stable, has no duplicates,
+// and follows the order of Walk
+func TestGetServers(t *testing.T) {
+	algorithms := []string{AlgorithmVnode, AlgorithmRendezvous, AlgorithmJump, AlgorithmMaglev}
+
+	membership := newTestMembers(5)
+
+	for _, algorithm := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			snapshot := newTestRing(t, algorithm, membership).Snapshot()
+
+			// The same members added in another order build the same ring
+			reversed := slices.Clone(membership)
+			slices.Reverse(reversed)
+			otherSnapshot := newTestRing(t, algorithm, reversed).Snapshot()
+
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("user:%d", i)
+
+				walked := []string{}
+				snapshot.Walk(key, func(server string) bool {
+					walked = append(walked, server)
+					return true
+				})
+
+				for _, n := range []int{0, 1, 3, len(membership), len(membership) + 2} {
+					servers := snapshot.GetServers(key, n)
+					if !slices.Equal(servers, walked[:min(n, len(walked))]) {
+						t.Fatalf("key '%s' with n=%d: got %v, walked %v", key, n, servers, walked)
+					}
+
+					if !slices.Equal(servers, otherSnapshot.GetServers(key, n)) {
+						t.Fatalf("key '%s' with n=%d: servers changed between rings", key, n)
+					}
+
+					sorted := slices.Clone(servers)
+					slices.Sort(sorted)
+					if len(slices.Compact(sorted)) != len(servers) {
+						t.Fatalf("key '%s' with n=%d: duplicated servers in %v", key, n, servers)
+					}
+				}
+
+				if servers := snapshot.GetServers(key, 1); servers[0] != snapshot.GetServer(key) {
+					t.Fatalf("key '%s': first server '%s' is not the one of the key", key, servers[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+import (
+	"slices"
+)
+
+// jumpBalancer implements Jump Consistent Hash (Lamping & Veach).
+// It needs no memory besides the list of servers and spreads keys evenly,
+// but buckets are positional: servers are kept sorted, so only adding or removing
+// the last server of the list moves the minimum amount of keys.
+// Each server owns as many buckets as its weight
+type jumpBalancer struct {
+	buckets []string
+	hasher  Hasher
+}
+
+func newJumpBalancer(servers []string, weights map[string]int, hasher Hasher) *jumpBalancer {
+	b := &jumpBalancer{
+		hasher: hasher,
+	}
+
+	// Expand the servers into buckets according to their weights
+	for _, server := range servers {
+		for i := 0; i < weights[server]; i++ {
+			b.buckets = append(b.buckets, server)
+		}
+	}
+
+	return b
+}
+
+func (b *jumpBalancer) Walk(key string, visit func(server string) bool) {
+	if len(b.buckets) == 0 {
+		return
+	}
+
+	// Next servers are selected jumping again over the remaining buckets with a rehashed key.
+	// Buckets are only copied when more than the first server is needed
+	candidates := b.buckets
+	keyHash := mix64(b.hasher.Sum64([]byte(key)))
+	for len(candidates) > 0 {
+		server := candidates[jumpHash(keyHash, len(candidates))]
+		if !visit(server) {
+			return
+		}
+		if len(candidates) == len(b.buckets) {
+			candidates = slices.Clone(candidates)
+		}
+		candidates = slices.DeleteFunc(candidates, func(s string) bool {
+			return s == server
+		})
+		keyHash = mix64(keyHash)
+	}
+}
+
+// jumpHash returns the bucket in range [0, numBuckets) for the given key
+// Ref: https://arxiv.org/abs/1406.2294
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+
+	// Amount of MD5 digests computed per server in 'ketama' mode (4 points per digest),
+	// multiplied by the share of the total weight owned by the server and the amount of servers
+	ketamaDigestsPerServer = 40
+
+	// Amount of points placed per weight unit in 'nginx' mode
+	nginxPointsPerWeight = 160
+)
+
+// newKetamaBalancer returns a ring whose points are placed exactly like libketama does,
+// so keys are routed to the same servers as libketama clients when servers are named as 'host:port'
+// Ref: https://github.com/RJ/ketama/blob/master/libketama/ketama.c
+func newKetamaBalancer(servers []string, weights map[string]int) *vnodeBalancer {
+	b := &vnodeBalancer{
+		hasher: ketamaHasher{},
+	}
+
+	totalWeight := 0
+	for _, server := range servers {
+		totalWeight += weights[server]
+	}
+
+	// The amount of digests is calculated with the same float precision libketama uses,
+	// as rounding differences would change the amount of points of the servers
+	for _, server := range servers {
+		pct := float32(weights[server]) / float32(totalWeight)
+		digests := int(math.Floor(float64(float32(float64(pct) * ketamaDigestsPerServer * float64(float32(len(servers)))))))
+
+		for i := 0; i < digests; i++ {
+			digest := md5.Sum([]byte(server + "-" + strconv.Itoa(i)))
+
+			// Successive 4 bytes of the digest are used as the points on the ring
+			for h := 0; h < 4; h++ {
+				b.nodes = append(b.nodes, Node{
+					hash:   uint64(binary.LittleEndian.Uint32(digest[h*4 : h*4+4])),
+					server: server,
+				})
+			}
+		}
+	}
+	sortNodes(b.nodes)
+
+	return b
+}
+
+// ketamaHasher hashes the keys like libketama: the first 4 bytes of the MD5 digest, read as little endian
+type ketamaHasher struct{}
+
+func (ketamaHasher) Sum64(data []byte) uint64 {
+	digest := md5.Sum(data)
+	return uint64(binary.LittleEndian.Uint32(digest[0:4]))
+}
+
+// newNginxBalancer returns a ring whose points are placed exactly like the 'hash ... consistent'
+// directive of nginx upstreams does, so keys are routed to the same servers as nginx
+// when servers are named as they are in the upstream block ('host:port')
+// Ref: https://github.com/nginx/nginx/blob/master/src/http/modules/ngx_http_upstream_hash_module.c
+func newNginxBalancer(servers []string, weights map[string]int) *vnodeBalancer {
+	b := &vnodeBalancer{
+		hasher: crc32Hasher{},
+	}
+
+	for _, server := range servers {
+
+		// Points are chained from a base hash: crc32(HOST \0 PORT PREV_HASH),
+		// being PREV_HASH the previous point in little endian
+		host, port := splitNginxServer(server)
+
+		baseHash := crc32.Update(0, crc32.IEEETable, []byte(host))
+		baseHash = crc32.Update(baseHash, crc32.IEEETable, []byte{0})
+		baseHash = crc32.Update(baseHash, crc32.IEEETable, []byte(port))
+
+		prevHash := make([]byte, 4)
+		for i := 0; i < nginxPointsPerWeight*weights[server]; i++ {
+			hash := crc32.Update(baseHash, crc32.IEEETable, prevHash)
+			b.nodes = append(b.nodes, Node{hash: uint64(hash), server: server})
+
+			binary.LittleEndian.PutUint32(prevHash, hash)
+		}
+	}
+	sortNodes(b.nodes)
+
+	// Points colliding with a previous one are dropped, as nginx does
+	uniqueNodes := b.nodes[:0]
+	for _, node := range b.nodes {
+		if len(uniqueNodes) > 0 && node.hash == uniqueNodes[len(uniqueNodes)-1].hash {
+			continue
+		}
+		uniqueNodes = append(uniqueNodes, node)
+	}
+	b.nodes = uniqueNodes
+
+	return b
+}
+
+// splitNginxServer splits the server into host and port like nginx does: the port is only
+// the digits after the last ':', so names like '[::1]' or 'host:name' are hosts without port
+func splitNginxServer(server string) (host string, port string) {
+	if len(server) >= 5 && strings.EqualFold(server[:5], "unix:") {
+		return server[5:], ""
+	}
+
+	for i := len(server) - 1; i >= 0; i-- {
+		if server[i] == ':' {
+			return server[:i], server[i+1:]
+		}
+
+		if server[i] < '0' || server[i] > '9' {
+			break
+		}
+	}
+
+	return server, ""
+}
+
+// sortNodes sorts the nodes by their position on the ring.
+// Colliding positions are sorted by server to keep the ring independent of the servers order
+func sortNodes(nodes []Node) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].hash != nodes[j].hash {
+			return nodes[i].hash < nodes[j].hash
+		}
+		return nodes[i].server < nodes[j].server
+	})
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestCompatibilityGolden checks that 'ketama' and 'nginx' modes route the keys to the same servers
+// as libketama and nginx do. Golden files are generated by 'testdata/compat_golden.c',
+// which transcribes the original implementations
+func TestCompatibilityGolden(t *testing.T) {
+	goldenFiles, err := filepath.Glob(filepath.Join("testdata", "*.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goldenFiles) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, goldenFile := range goldenFiles {
+		t.Run(filepath.Base(goldenFile), func(t *testing.T) {
+			file, err := os.Open(goldenFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			var ring *HashRing
+			var checkedKeys int
+
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				line := scanner.Text()
+
+				// Header lines configure the ring
+				if algorithm, found := strings.CutPrefix(line, "# algorithm: "); found {
+					ring, err = NewHashRing(algorithm, "")
+					if err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+
+				if servers, found := strings.CutPrefix(line, "# servers: "); found {
+					membership := []Member{}
+					for _, server := range strings.Fields(servers) {
+						separatorIndex := strings.LastIndex(server, "=")
+						weight, err := strconv.Atoi(server[separatorIndex+1:])
+						if err != nil {
+							t.Fatal(err)
+						}
+						membership = append(membership, Member{Name: server[:separatorIndex], Weight: weight})
+					}
+					ring.ApplyMembership(membership, nil)
+					continue
+				}
+
+				// Remaining lines are expected placements: '<key> <server>'
+				key, expectedServer, _ := strings.Cut(line, " ")
+				if server := ring.GetServer(key); server != expectedServer {
+					t.Errorf("key '%s' routed to '%s', expected '%s'", key, server, expectedServer)
+				}
+				checkedKeys++
+			}
+
+			if err := scanner.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if checkedKeys == 0 {
+				t.Fatal("no keys found in golden file")
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+const (
+
+	// Size of the Maglev lookup table. It must be a prime number
+	// much bigger than the expected amount of servers
+	defaultMaglevTableSize = 65537
+)
+
+// maglevBalancer implements Maglev hashing (Google's network load balancer).
+// Every server fills the lookup table following its own permutation, so all of them
+// own almost the same amount of entries, and lookups are a single O(1) table access.
+// The table is built for each membership.
+// On each populating round, servers take as many entries as their weight
+type maglevBalancer struct {
+	servers   []string
+	weights   map[string]int
+	hasher    Hasher
+	table     []int
+	tableSize uint64
+}
+
+func newMaglevBalancer(servers []string, weights map[string]int, hasher Hasher, tableSize uint64) *maglevBalancer {
+	b := &maglevBalancer{
+		servers:   servers,
+		weights:   weights,
+		hasher:    hasher,
+		tableSize: tableSize,
+	}
+	b.populate()
+
+	return b
+}
+
+func (b *maglevBalancer) Walk(key string, visit func(server string) bool) {
+	if len(b.table) == 0 {
+		return
+	}
+
+	// Walk the lookup table from the key entry, wrapping at the end of it
+	idx := mix64(b.hasher.Sum64([]byte(key))) % b.tableSize
+	visited := make([]bool, len(b.servers))
+	pending := len(b.servers)
+	for i := uint64(0); i < b.tableSize && pending > 0; i++ {
+		server := b.table[(idx+i)%b.tableSize]
+		if visited[server] {
+			continue
+		}
+		visited[server] = true
+		pending--
+
+		if !visit(b.servers[server]) {
+			return
+		}
+	}
+}
+
+// populate fills the lookup table with the current servers
+// Ref: https://research.google/pubs/maglev-a-fast-and-reliable-software-network-load-balancer/
+func (b *maglevBalancer) populate() {
+	if len(b.servers) == 0 {
+		b.table = nil
+		return
+	}
+
+	offsets := make([]uint64, len(b.servers))
+	skips := make([]uint64, len(b.servers))
+	for i, server := range b.servers {
+		serverHash := mix64(b.hasher.Sum64([]byte(server)))
+		offsets[i] = serverHash % b.tableSize
+		skips[i] = mix64(serverHash)%(b.tableSize-1) + 1
+	}
+
+	table := make([]int, b.tableSize)
+	for i := range table {
+		table[i] = -1
+	}
+
+	next := make([]uint64, len(b.servers))
+	var filled uint64
+	for {
+		for i, server := range b.servers {
+			for turn := 0; turn < b.weights[server]; turn++ {
+				entry := (offsets[i] + next[i]*skips[i]) % b.tableSize
+				for table[entry] >= 0 {
+					next[i]++
+					entry = (offsets[i] + next[i]*skips[i]) % b.tableSize
+				}
+				table[entry] = i
+				next[i]++
+				filled++
+
+				if filled == b.tableSize {
+					b.table = table
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"strings"
+)
+
+// rendezvousBalancer implements Highest Random Weight hashing.
+// Each server gets a score for the key, and the highest one wins.
+// Only the keys owned by a server move when it is removed, at the cost of O(n) lookups.
+// Scores are weighted using the logarithmic method, so each server wins
+// a share of keys proportional to its weight
+type rendezvousBalancer struct {
+	servers []rendezvousServer
+	hasher  Hasher
+}
+
+type rendezvousServer struct {
+	name   string
+	hash   uint64
+	weight float64
+}
+
+func newRendezvousBalancer(servers []string, weights map[string]int, hasher Hasher) *rendezvousBalancer {
+	b := &rendezvousBalancer{
+		hasher: hasher,
+	}
+
+	for _, server := range servers {
+		b.servers = append(b.servers, rendezvousServer{
+			name:   server,
+			hash:   hasher.Sum64([]byte(server)),
+			weight: float64(weights[server]),
+		})
+	}
+
+	return b
+}
+
+func (b *rendezvousBalancer) Walk(key string, visit func(server string) bool) {
+	keyHash := b.hasher.Sum64([]byte(key))
+
+	type candidate struct {
+		name  string
+		score float64
+	}
+
+	candidates := make([]candidate, 0, len(b.servers))
+	for _, server := range b.servers {
+		candidates = append(candidates, candidate{
+			name:  server.name,
+			score: weightedScore(mix64(server.hash^keyHash), server.weight),
+		})
+	}
+
+	// Ties are broken by name to keep the result independent of the insertion order
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		if a.score != b.score {
+			return cmp.Compare(b.score, a.score)
+		}
+		return strings.Compare(a.name, b.name)
+	})
+
+	for _, c := range candidates {
+		if !visit(c.name) {
+			return
+		}
+	}
+}
+
+// weightedScore turns a hash into a score following the logarithmic method: -weight / ln(u),
+// being 'u' the hash mapped into the range (0, 1)
+// Ref: https://www.snia.org/sites/default/files/SDC15_presentations/dist_sys/Jason_Resch_New_Consistent_Hashings_Rev.pdf
+func weightedScore(hash uint64, weight float64) float64 {
+	u := (float64(hash>>11) + 0.5) / (1 << 53)
+	return -weight / math.Log(u)
+}
@@ -0,0 +1,264 @@
+/*
+ * SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+ * SPDX-License-Identifier: Apache-2.0
+ *
+ * Generator of the golden files used to check the compatibility of the 'ketama' and 'nginx' modes.
+ * Continuum creation and lookups are transcribed from the original sources:
+ *   - libketama: ketama_create_continuum(), ketama_hashi(), ketama_get_server()
+ *   - nginx:     ngx_http_upstream_init_chash(), ngx_http_upstream_find_chash_point()
+ *
+ * Build and run:
+ *   cc -o compat_golden compat_golden.c -lcrypto -lz -lm
+ *   ./compat_golden ketama 10.0.1.1:11211=1 10.0.1.2:11211=1 > ketama_equal.golden
+ */
+
+#include <math.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include <openssl/md5.h>
+#include <zlib.h>
+
+#define MAX_SERVERS 64
+#define MAX_POINTS  (MAX_SERVERS * 160 * 1000)
+
+typedef struct {
+    uint32_t point;
+    int      server;
+} point_t;
+
+static char    *names[MAX_SERVERS];
+static int      weights[MAX_SERVERS];
+static int      nservers;
+static point_t  points[MAX_POINTS];
+static int      npoints;
+
+static int compare_points(const void *a, const void *b)
+{
+    const point_t *pa = a, *pb = b;
+
+    if (pa->point != pb->point) {
+        return pa->point < pb->point ? -1 : 1;
+    }
+    /* Ties are not ordered by the original implementations. Sort them by name to be deterministic */
+    return strcmp(names[pa->server], names[pb->server]);
+}
+
+/* libketama: ketama_create_continuum() */
+static void ketama_continuum(void)
+{
+    unsigned long memory = 0;
+    int i, k, h;
+
+    for (i = 0; i < nservers; i++) {
+        memory += weights[i];
+    }
+
+    for (i = 0; i < nservers; i++) {
+        float pct = (float) weights[i] / (float) memory;
+        unsigned int ks = floorf(pct * 40.0 * (float) nservers);
+
+        for (k = 0; k < (int) ks; k++) {
+            char ss[256];
+            unsigned char digest[16];
+
+            snprintf(ss, sizeof(ss), "%s-%d", names[i], k);
+            MD5((unsigned char *) ss, strlen(ss), digest);
+
+            for (h = 0; h < 4; h++) {
+                points[npoints].point = (digest[3 + h * 4] << 24) | (digest[2 + h * 4] << 16)
+                                      | (digest[1 + h * 4] << 8) | digest[h * 4];
+                points[npoints].server = i;
+                npoints++;
+            }
+        }
+    }
+
+    qsort(points, npoints, sizeof(point_t), compare_points);
+}
+
+/* libketama: ketama_hashi() */
+static uint32_t ketama_hashi(const char *key)
+{
+    unsigned char digest[16];
+
+    MD5((const unsigned char *) key, strlen(key), digest);
+    return (digest[3] << 24) | (digest[2] << 16) | (digest[1] << 8) | digest[0];
+}
+
+/* libketama: ketama_get_server() */
+static int ketama_get_server(const char *key)
+{
+    uint32_t h = ketama_hashi(key);
+    int highp = npoints;
+    int lowp = 0, midp;
+    uint32_t midval, midval1;
+
+    while (1) {
+        midp = (int) ((lowp + highp) / 2);
+
+        if (midp == npoints) {
+            return points[0].server;
+        }
+
+        midval = points[midp].point;
+        midval1 = midp == 0 ? 0 : points[midp - 1].point;
+
+        if (h <= midval && h > midval1) {
+            return points[midp].server;
+        }
+
+        if (midval < h) {
+            lowp = midp + 1;
+        } else {
+            highp = midp - 1;
+        }
+
+        if (lowp > highp) {
+            return points[0].server;
+        }
+    }
+}
+
+/* nginx: ngx_http_upstream_init_chash() */
+static void nginx_continuum(void)
+{
+    int i, j;
+
+    for (i = 0; i < nservers; i++) {
+        const char *server = names[i];
+        size_t len = strlen(server);
+        const char *host, *port;
+        size_t host_len, port_len;
+        uLong base_hash, hash;
+        union {
+            uint32_t value;
+            unsigned char byte[4];
+        } prev_hash;
+
+        if (len >= 5 && strncasecmp(server, "unix:", 5) == 0) {
+            host = server + 5;
+            host_len = len - 5;
+            port = NULL;
+            port_len = 0;
+            goto done;
+        }
+
+        for (j = 0; j < (int) len; j++) {
+            char c = server[len - j - 1];
+
+            if (c == ':') {
+                host = server;
+                host_len = len - j - 1;
+                port = server + len - j;
+                port_len = j;
+                goto done;
+            }
+
+            if (c < '0' || c > '9') {
+                break;
+            }
+        }
+
+        host = server;
+        host_len = len;
+        port = NULL;
+        port_len = 0;
+
+    done:
+        base_hash = crc32(0L, (const Bytef *) host, host_len);
+        base_hash = crc32(base_hash, (const Bytef *) "", 1);
+        /* zlib returns the initial value when the buffer is NULL, while nginx just skips it */
+        if (port != NULL) {
+            base_hash = crc32(base_hash, (const Bytef *) port, port_len);
+        }
+
+        prev_hash.value = 0;
+
+        for (j = 0; j < weights[i] * 160; j++) {
+            hash = crc32(base_hash, prev_hash.byte, 4);
+
+            points[npoints].point = (uint32_t) hash;
+            points[npoints].server = i;
+            npoints++;
+
+            /* Little endian host assumed, as nginx does on it */
+            prev_hash.value = (uint32_t) hash;
+        }
+    }
+
+    qsort(points, npoints, sizeof(point_t), compare_points);
+
+    for (i = 0, j = 1; j < npoints; j++) {
+        if (points[i].point != points[j].point) {
+            points[++i] = points[j];
+        }
+    }
+    npoints = i + 1;
+}
+
+/* nginx: ngx_http_upstream_find_chash_point() */
+static int nginx_get_server(const char *key)
+{
+    uint32_t hash = (uint32_t) crc32(0L, (const Bytef *) key, strlen(key));
+    int i = 0, j = npoints, k;
+
+    while (i < j) {
+        k = (i + j) / 2;
+
+        if (hash > points[k].point) {
+            i = k + 1;
+        } else if (hash < points[k].point) {
+            j = k;
+        } else {
+            i = k;
+            break;
+        }
+    }
+
+    return points[i % npoints].server;
+}
+
+int main(int argc, char **argv)
+{
+    int i, nginx;
+    char key[64];
+
+    if (argc < 3) {
+        fprintf(stderr, "usage: %s <ketama|nginx> <host:port=weight>...\n", argv[0]);
+        return 1;
+    }
+    nginx = strcmp(argv[1], "nginx") == 0;
+
+    printf("# algorithm: %s\n# servers:", argv[1]);
+    for (i = 2; i < argc && nservers < MAX_SERVERS; i++) {
+        char *sep = strrchr(argv[i], '=');
+
+        *sep = '\0';
+        names[nservers] = argv[i];
+        weights[nservers] = atoi(sep + 1);
+        printf(" %s=%d", names[nservers], weights[nservers]);
+        nservers++;
+    }
+    printf("\n");
+
+    if (nginx) {
+        nginx_continuum();
+    } else {
+        ketama_continuum();
+    }
+
+    for (i = 0; i < 1000; i++) {
+        if (i % 2 == 0) {
+            snprintf(key, sizeof(key), "/static/img/%d.png", i);
+        } else {
+            snprintf(key, sizeof(key), "user:%d", i);
+        }
+
+        printf("%s %s\n", key, names[nginx ? nginx_get_server(key) : ketama_get_server(key)]);
+    }
+
+    return 0;
+}
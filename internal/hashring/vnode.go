@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+import (
+	"sort"
+	"strconv"
+)
+
+// vnodeBalancer places several virtual nodes for each server on a ring.
+// Keys are routed to the first virtual node found walking clockwise from their position,
+// so adding or removing a server only moves the keys of the arcs it owns.
+// The amount of virtual nodes of a server is multiplied by its weight. Weights are reduced by their GCD first,
+// and the virtual nodes per weight unit are lowered when needed, so the ring never exceeds 'maxVnodes' nodes
+type vnodeBalancer struct {
+	nodes  []Node
+	hasher Hasher
+}
+
+type Node struct {
+	hash   uint64
+	server string
+}
+
+func newVnodeBalancer(servers []string, weights map[string]int, hasher Hasher, vnodesPerNode int) *vnodeBalancer {
+
+	// Positions are mixed, as functions like FNV-1a cluster similar keys on the ring.
+	// CRC32 ones are kept raw to preserve the placements of the rings created before
+	if _, isCrc32 := hasher.(crc32Hasher); !isCrc32 {
+		hasher = mixedHasher{hasher: hasher}
+	}
+
+	b := &vnodeBalancer{
+		hasher: hasher,
+	}
+
+	// Only the proportion between the weights matters, so they are reduced to the smallest equivalent ones
+	divisor := 0
+	for _, server := range servers {
+		divisor = gcd(divisor, weights[server])
+	}
+
+	weightUnits := 0
+	for _, server := range servers {
+		weightUnits += weights[server] / divisor
+	}
+
+	if weightUnits > 0 {
+		vnodesPerNode = max(min(vnodesPerNode, maxVnodes/weightUnits), 1)
+	}
+
+	for _, server := range servers {
+		for i := 0; i < vnodesPerNode*weights[server]/divisor; i++ {
+			vnode := server + "#" + strconv.Itoa(i)
+			hash := hasher.Sum64([]byte(vnode))
+			b.nodes = append(b.nodes, Node{hash: hash, server: server})
+		}
+	}
+	sort.Slice(b.nodes, func(i, j int) bool {
+		return b.nodes[i].hash < b.nodes[j].hash
+	})
+
+	return b
+}
+
+func (b *vnodeBalancer) Walk(key string, visit func(server string) bool) {
+	if len(b.nodes) == 0 {
+		return
+	}
+	hash := b.hasher.Sum64([]byte(key))
+	idx := sort.Search(len(b.nodes), func(i int) bool {
+		return b.nodes[i].hash >= hash
+	})
+
+	// Walk clockwise from the key position, wrapping at the end of the ring
+	visited := map[string]struct{}{}
+	for i := 0; i < len(b.nodes); i++ {
+		server := b.nodes[(idx+i)%len(b.nodes)].server
+		if _, found := visited[server]; found {
+			continue
+		}
+		visited[server] = struct{}{}
+
+		if !visit(server) {
+			return
+		}
+	}
+}
+
+// gcd returns the greatest common divisor of the given numbers. The GCD of 0 and 'b' is 'b'
+func gcd(a int, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package hashring
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestVnodeDistribution checks that every hash function spreads path-like keys evenly across the servers
+func TestVnodeDistribution(t *testing.T) {
+	const (
+		numServers = 8
+		numKeys    = 200000
+	)
+
+	hashFunctions := []string{HashFunctionCrc32, HashFunctionFnv1a, HashFunctionMurmur3, HashFunctionXxhash64}
+
+	for _, hashFunction := range hashFunctions {
+		t.Run(hashFunction, func(t *testing.T) {
+			ring, err := NewHashRing(AlgorithmVnode, hashFunction)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			membership := []Member{}
+			for i := 0; i < numServers; i++ {
+				membership = append(membership, Member{Name: fmt.Sprintf("10.0.0.%d:80", i+1), Weight: 1})
+			}
+			ring.ApplyMembership(membership, nil)
+
+			counts := map[string]int{}
+			for i := 0; i < numKeys; i++ {
+				counts[ring.GetServer(fmt.Sprintf("/api/v1/users/%d/profile", i))]++
+			}
+
+			fairShare := float64(numKeys) / numServers
+			for _, member := range membership {
+				if ratio := float64(counts[member.Name]) / fairShare; ratio < 0.85 || ratio > 1.15 {
+					t.Errorf("server '%s' got %.2f times its fair share", member.Name, ratio)
+				}
+			}
+		})
+	}
+}
@@ -46,11 +46,67 @@ func (p *PoolT) RegisterMetrics(extraLabelNames []string) {
 	parsedLabels := maps.Values(parsedLabelsMap)
 
 	// Metric: http_requests_total
-	httpRequestsTotalLabels := []string{"proxy_name", "method", "delivered_status_code", "error"}
+	httpRequestsTotalLabels := []string{"proxy_name", "route", "method", "delivered_status_code", "error"}
 	httpRequestsTotalLabels = append(httpRequestsTotalLabels, parsedLabels...)
 
 	p.HttpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: MetricsPrefix + "http_requests_total",
 		Help: "total amount of requests by status code",
 	}, httpRequestsTotalLabels)
+
+	// Metric: bounded_load_overflows_total
+	p.BoundedLoadOverflowsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "bounded_load_overflows_total",
+		Help: "total amount of requests moved away from their overloaded backend",
+	}, []string{"proxy_name", "route", "backend"})
+
+	// Metric: hash_key_fallback_level_total
+	p.HashKeyFallbackLevelTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "hash_key_fallback_level_total",
+		Help: "total amount of hash keys calculated by the level of the pattern that produced them",
+	}, []string{"proxy_name", "route", "level"})
+
+	// Metric: request_attempts
+	p.RequestAttempts = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    MetricsPrefix + "request_attempts",
+		Help:    "amount of backends tried to serve each request",
+		Buckets: prometheus.LinearBuckets(1, 1, 10),
+	}, []string{"proxy_name", "route"})
+
+	// Metric: backend_health_transitions_total
+	p.HealthTransitionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "backend_health_transitions_total",
+		Help: "state transitions of the backends health: healthy, unhealthy, flapping or stable",
+	}, []string{"proxy_name", "route", "backend", "state"})
+
+	// Metric: backend_outlier_ejections_total
+	p.OutlierEjectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: MetricsPrefix + "backend_outlier_ejections_total",
+		Help: "backends ejected from the selection because of failing consecutive requests",
+	}, []string{"proxy_name", "route", "backend"})
+
+	// Metric: backend_circuit_breaker_state
+	p.CircuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "backend_circuit_breaker_state",
+		Help: "state of the circuit breaker of the backends: 0 closed, 1 half-open, 2 open",
+	}, []string{"proxy_name", "route", "backend"})
+
+	// Metric: healthcheck_duration_seconds
+	p.HealthCheckDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    MetricsPrefix + "healthcheck_duration_seconds",
+		Help:    "latency of the health checks performed against the backends",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"proxy_name", "route", "backend", "result"})
+
+	// Metric: backend_open_connections
+	p.BackendOpenConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "backend_open_connections",
+		Help: "amount of connections currently open to the backend, including the idle ones",
+	}, []string{"proxy_name", "route", "backend"})
+
+	// Metric: backend_idle_connections
+	p.BackendIdleConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: MetricsPrefix + "backend_idle_connections",
+		Help: "amount of connections currently open to the backend waiting to be reused (HTTP/1 backends only)",
+	}, []string{"proxy_name", "route", "backend"})
 }
@@ -8,4 +8,13 @@ import "github.com/prometheus/client_golang/prometheus"
 type PoolT struct {
 	HttpRequestsTotal              *prometheus.CounterVec
 	BackendConnectionFailuresTotal *prometheus.CounterVec
+	BoundedLoadOverflowsTotal      *prometheus.CounterVec
+	HashKeyFallbackLevelTotal      *prometheus.CounterVec
+	HealthTransitionsTotal         *prometheus.CounterVec
+	OutlierEjectionsTotal          *prometheus.CounterVec
+	BackendOpenConnections         *prometheus.GaugeVec
+	BackendIdleConnections         *prometheus.GaugeVec
+	CircuitBreakerState            *prometheus.GaugeVec
+	RequestAttempts                *prometheus.HistogramVec
+	HealthCheckDuration            *prometheus.HistogramVec
 }
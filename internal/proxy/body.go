@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+
+	// Bytes of the request body kept in memory before spilling the rest into a temporary file.
+	// (default: 1MiB)
+	defaultRetryBodyBufferMemoryBytes = 1 << 20
+
+	// Maximum bytes of the request body buffered for retries.
+	// Requests with bigger bodies are not retried
+	// (default: 16MiB)
+	defaultRetryBodyBufferMaxBytes = 16 << 20
+)
+
+var (
+	errRetryBodyReaderReplaced = errors.New("request body reader replaced by a newer attempt")
+)
+
+// RetryBodyT buffers the request body while it is being sent to a backend, so it can be sent again
+// to another backend when the attempt fails. The body is read from the client only once:
+// new readers replay the buffered part first, then continue reading from the client.
+// Buffering stops when the body exceeds the maximum size. From that moment, the body is not replayable
+type RetryBodyT struct {
+	sync.Mutex
+
+	//
+	source      io.Reader
+	sourceDone  bool
+	sourceError error
+
+	//
+	memory      bytes.Buffer
+	memoryLimit int64
+	file        *os.File
+	size        int64
+	maxSize     int64
+	overflowed  bool
+
+	// Only the reader of the latest attempt is allowed to read
+	generation int
+}
+
+type retryBodyReaderT struct {
+	body       *RetryBodyT
+	generation int
+	offset     int64
+}
+
+// NewRetryBody returns a RetryBodyT reading from the given source.
+// A negative maximum size disables the buffering, so the body is never replayable
+func NewRetryBody(source io.Reader, memoryLimit int64, maxSize int64) *RetryBodyT {
+
+	if memoryLimit <= 0 {
+		memoryLimit = defaultRetryBodyBufferMemoryBytes
+	}
+
+	if maxSize == 0 {
+		maxSize = defaultRetryBodyBufferMaxBytes
+	}
+
+	return &RetryBodyT{
+		source:      source,
+		memoryLimit: memoryLimit,
+		maxSize:     maxSize,
+		overflowed:  maxSize < 0,
+	}
+}
+
+// IsReplayable returns whether the whole body read so far is buffered, so a new reader can be created
+func (b *RetryBodyT) IsReplayable() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	return !b.overflowed
+}
+
+// NewReader returns a reader of the body from its beginning.
+// Readers created before stop working, as they belong to attempts already finished
+func (b *RetryBodyT) NewReader() io.ReadCloser {
+	b.Lock()
+	defer b.Unlock()
+
+	b.generation++
+	return &retryBodyReaderT{
+		body:       b,
+		generation: b.generation,
+	}
+}
+
+// Close releases the resources used by the buffer, removing the temporary file if any
+func (b *RetryBodyT) Close() error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.generation++
+	return b.release()
+}
+
+// release discards the buffered content. It must be called with the lock held
+func (b *RetryBodyT) release() (err error) {
+	b.memory = bytes.Buffer{}
+	b.size = 0
+
+	if b.file != nil {
+		err = b.file.Close()
+		os.Remove(b.file.Name())
+		b.file = nil
+	}
+	return err
+}
+
+// store appends the given data to the buffer, spilling it into a temporary file
+// once the memory limit is reached. It must be called with the lock held
+func (b *RetryBodyT) store(data []byte) {
+	if b.overflowed {
+		return
+	}
+
+	if b.size+int64(len(data)) > b.maxSize {
+		b.overflowed = true
+		b.release()
+		return
+	}
+
+	if b.file == nil && int64(b.memory.Len()+len(data)) <= b.memoryLimit {
+		b.memory.Write(data)
+		b.size += int64(len(data))
+		return
+	}
+
+	var err error
+	if b.file == nil {
+		b.file, err = os.CreateTemp("", "hashrouter-body-*")
+		if err != nil {
+			b.overflowed = true
+			b.release()
+			return
+		}
+	}
+
+	if _, err = b.file.Write(data); err != nil {
+		b.overflowed = true
+		b.release()
+		return
+	}
+	b.size += int64(len(data))
+}
+
+func (r *retryBodyReaderT) Read(p []byte) (n int, err error) {
+	b := r.body
+
+	b.Lock()
+	defer b.Unlock()
+
+	if r.generation != b.generation {
+		return 0, errRetryBodyReaderReplaced
+	}
+
+	// Replay the buffered part first
+	if r.offset < b.size {
+		memoryLen := int64(b.memory.Len())
+
+		if r.offset < memoryLen {
+			n = copy(p, b.memory.Bytes()[r.offset:])
+		} else {
+			n, err = b.file.ReadAt(p[:min(int64(len(p)), b.size-r.offset)], r.offset-memoryLen)
+			if err == io.EOF {
+				err = nil
+			}
+		}
+
+		r.offset += int64(n)
+		return n, err
+	}
+
+	// Then continue reading from the client
+	if b.sourceDone {
+		if b.sourceError != nil {
+			return 0, b.sourceError
+		}
+		return 0, io.EOF
+	}
+
+	n, err = b.source.Read(p)
+	if n > 0 {
+		b.store(p[:n])
+		r.offset += int64(n)
+	}
+
+	if err != nil {
+		b.sourceDone = true
+		if err != io.EOF {
+			b.sourceError = err
+		}
+	}
+
+	return n, err
+}
+
+// Close does nothing, as the body is shared by all the attempts
+func (r *retryBodyReaderT) Close() error {
+	return nil
+}
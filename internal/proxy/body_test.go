@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRetryBodyReplay checks that every reader gets the whole body, in memory and spilled into a file,
+// reading the client only once
+func TestRetryBodyReplay(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		memoryLimit int64
+		spilled     bool
+	}{
+		{name: "empty", size: 0, memoryLimit: 16},
+		{name: "memory", size: 10, memoryLimit: 16},
+		{name: "memory limit", size: 16, memoryLimit: 16},
+		{name: "spilled", size: 100, memoryLimit: 16, spilled: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+
+			content := bytes.Repeat([]byte("0123456789"), test.size/10+1)[:test.size]
+			source := &countingReaderT{reader: bytes.NewReader(content)}
+
+			body := NewRetryBody(source, test.memoryLimit, 1024)
+
+			// The first reader is stopped halfway, as a failed attempt would do
+			firstReader := body.NewReader()
+			partial := make([]byte, test.size/2)
+			if _, err := io.ReadFull(firstReader, partial); err != nil {
+				t.Fatalf("error reading first half: %s", err.Error())
+			}
+
+			for attempt := 0; attempt < 2; attempt++ {
+				got, err := io.ReadAll(body.NewReader())
+				if err != nil {
+					t.Fatalf("attempt %d: error reading body: %s", attempt, err.Error())
+				}
+				if !bytes.Equal(got, content) {
+					t.Fatalf("attempt %d: got %q, want %q", attempt, got, content)
+				}
+			}
+
+			if !body.IsReplayable() {
+				t.Fatal("body should be replayable")
+			}
+
+			if source.read != test.size {
+				t.Fatalf("source read %d bytes, want %d", source.read, test.size)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(os.TempDir(), "hashrouter-body-*"))
+			if (len(files) > 0) != test.spilled {
+				t.Fatalf("temporary files: %v, spilled: %t", files, test.spilled)
+			}
+
+			if err := body.Close(); err != nil {
+				t.Fatalf("error closing body: %s", err.Error())
+			}
+
+			files, _ = filepath.Glob(filepath.Join(os.TempDir(), "hashrouter-body-*"))
+			if len(files) > 0 {
+				t.Fatalf("temporary files not removed: %v", files)
+			}
+		})
+	}
+}
+
+// TestRetryBodyGeneration checks that only the reader of the latest attempt can read
+func TestRetryBodyGeneration(t *testing.T) {
+	body := NewRetryBody(bytes.NewReader([]byte("content")), 0, 0)
+	defer body.Close()
+
+	oldReader := body.NewReader()
+	newReader := body.NewReader()
+
+	if _, err := oldReader.Read(make([]byte, 1)); !errors.Is(err, errRetryBodyReaderReplaced) {
+		t.Fatalf("old reader error: got %v, want %v", err, errRetryBodyReaderReplaced)
+	}
+
+	got, err := io.ReadAll(newReader)
+	if err != nil || string(got) != "content" {
+		t.Fatalf("new reader: got %q, %v", got, err)
+	}
+
+	body.Close()
+	if _, err := newReader.Read(make([]byte, 1)); !errors.Is(err, errRetryBodyReaderReplaced) {
+		t.Fatalf("reader after close error: got %v, want %v", err, errRetryBodyReaderReplaced)
+	}
+}
+
+// TestRetryBodyOverflow checks that bodies bigger than the maximum size are still sent once,
+// but are not replayable anymore
+func TestRetryBodyOverflow(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		maxSize    int64
+		replayable bool
+	}{
+		{name: "under maximum", size: 64, maxSize: 64, replayable: true},
+		{name: "over maximum", size: 65, maxSize: 64, replayable: false},
+		{name: "buffering disabled", size: 1, maxSize: -1, replayable: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+
+			content := bytes.Repeat([]byte("x"), test.size)
+			body := NewRetryBody(bytes.NewReader(content), 16, test.maxSize)
+			defer body.Close()
+
+			got, err := io.ReadAll(body.NewReader())
+			if err != nil || !bytes.Equal(got, content) {
+				t.Fatalf("first reader: got %d bytes, %v", len(got), err)
+			}
+
+			if body.IsReplayable() != test.replayable {
+				t.Fatalf("replayable: got %t, want %t", body.IsReplayable(), test.replayable)
+			}
+
+			// Overflowed bodies release what was buffered
+			if !test.replayable {
+				files, _ := filepath.Glob(filepath.Join(os.TempDir(), "hashrouter-body-*"))
+				if len(files) > 0 {
+					t.Fatalf("temporary files not removed: %v", files)
+				}
+			}
+		})
+	}
+}
+
+// TestRetryBodySourceError checks that the errors reading the client are returned to every reader
+func TestRetryBodySourceError(t *testing.T) {
+	sourceErr := errors.New("client went away")
+	body := NewRetryBody(io.MultiReader(bytes.NewReader([]byte("abc")), &failingReaderT{err: sourceErr}), 0, 0)
+	defer body.Close()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		got, err := io.ReadAll(body.NewReader())
+		if !errors.Is(err, sourceErr) || string(got) != "abc" {
+			t.Fatalf("attempt %d: got %q, %v", attempt, got, err)
+		}
+	}
+}
+
+// countingReaderT counts the bytes read from the underlying reader
+type countingReaderT struct {
+	reader io.Reader
+	read   int
+}
+
+func (r *countingReaderT) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+// failingReaderT always fails with the given error
+type failingReaderT struct {
+	err error
+}
+
+func (r *failingReaderT) Read(p []byte) (int, error) {
+	return 0, r.err
+}
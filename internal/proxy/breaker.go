@@ -0,0 +1,337 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"hashrouter/api"
+)
+
+const (
+
+	// Time covered by the rolling window of results
+	// (default: 10s)
+	defaultCircuitBreakerWindow = 10 * time.Second
+
+	// Requests needed inside the window before the error rate is evaluated
+	// (default: 20)
+	defaultCircuitBreakerMinimumRequests = 20
+
+	// Rate of failed requests opening the circuit
+	// (default: 0.5)
+	defaultCircuitBreakerErrorRateThreshold = 0.5
+
+	// Time the circuit is open before allowing trial requests
+	// (default: 30s)
+	defaultCircuitBreakerOpenTime = 30 * time.Second
+
+	// Trial requests allowed while the circuit is half-open. All of them must succeed to close it
+	// (default: 1)
+	defaultCircuitBreakerHalfOpenMaxRequests = 1
+
+	// Amount of buckets the rolling window is divided into
+	circuitBreakerWindowBuckets = 10
+
+	// States of the circuits
+	CircuitStateClosed   = "closed"
+	CircuitStateOpen     = "open"
+	CircuitStateHalfOpen = "half_open"
+)
+
+var (
+	errCircuitOpen = errors.New("circuit breaker is open")
+
+	// Values of the states in the metrics
+	circuitStateValues = map[string]float64{
+		CircuitStateClosed:   0,
+		CircuitStateHalfOpen: 1,
+		CircuitStateOpen:     2,
+	}
+)
+
+// CircuitBreakerT stops sending requests to the backends failing too many of them.
+// Each backend has its own circuit: closed sends everything, open sends nothing,
+// and half-open sends a limited amount of trial requests to decide whether to close it again
+type CircuitBreakerT struct {
+	sync.Mutex
+
+	//
+	enabled             bool
+	window              time.Duration
+	minimumRequests     int
+	errorRateThreshold  float64
+	latencyThreshold    time.Duration
+	openTime            time.Duration
+	halfOpenMaxRequests int
+
+	//
+	circuits map[string]*circuitT
+
+	// OnChange is called on every state change, with the lock held
+	OnChange func(backend string, state string)
+}
+
+// circuitT holds the state of the circuit of a single backend
+type circuitT struct {
+	state    string
+	openedAt time.Time
+	buckets  [circuitBreakerWindowBuckets]circuitBucketT
+
+	// Trial requests sent while half-open, and those succeeded
+	trials    int
+	successes int
+}
+
+// circuitBucketT holds the results of the requests finished during a slice of the window
+type circuitBucketT struct {
+	epoch    int64
+	requests int
+	failures int
+}
+
+// NewCircuitBreaker returns a CircuitBreakerT for the given configuration.
+// Circuits are never opened when nothing is configured
+func NewCircuitBreaker(config api.CircuitBreakerT) (breaker *CircuitBreakerT, err error) {
+
+	breaker = &CircuitBreakerT{
+		enabled:             !reflect.ValueOf(config).IsZero(),
+		window:              defaultCircuitBreakerWindow,
+		minimumRequests:     defaultCircuitBreakerMinimumRequests,
+		errorRateThreshold:  defaultCircuitBreakerErrorRateThreshold,
+		latencyThreshold:    config.LatencyThreshold,
+		openTime:            defaultCircuitBreakerOpenTime,
+		halfOpenMaxRequests: defaultCircuitBreakerHalfOpenMaxRequests,
+		circuits:            make(map[string]*circuitT),
+	}
+
+	if config.Window < 0 || config.MinimumRequests < 0 || config.LatencyThreshold < 0 ||
+		config.OpenTime < 0 || config.HalfOpenMaxRequests < 0 {
+		return nil, fmt.Errorf("values can not be negative")
+	}
+
+	if config.ErrorRateThreshold < 0 || config.ErrorRateThreshold > 1 {
+		return nil, fmt.Errorf("error rate threshold must be between 0 and 1")
+	}
+
+	if config.Window > 0 {
+		breaker.window = config.Window
+	}
+
+	if config.MinimumRequests > 0 {
+		breaker.minimumRequests = config.MinimumRequests
+	}
+
+	if config.ErrorRateThreshold > 0 {
+		breaker.errorRateThreshold = config.ErrorRateThreshold
+	}
+
+	if config.OpenTime > 0 {
+		breaker.openTime = config.OpenTime
+	}
+
+	if config.HalfOpenMaxRequests > 0 {
+		breaker.halfOpenMaxRequests = config.HalfOpenMaxRequests
+	}
+
+	return breaker, nil
+}
+
+// Allow returns whether a request can be sent to the given backend.
+// Allowed requests must be followed by a call to Report or Cancel
+func (b *CircuitBreakerT) Allow(backend string) bool {
+	if !b.enabled {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	circuit := b.getCircuit(backend)
+
+	// Open circuits become half-open once the open time is elapsed
+	if circuit.state == CircuitStateOpen {
+		if time.Since(circuit.openedAt) < b.openTime {
+			return false
+		}
+		b.setState(backend, circuit, CircuitStateHalfOpen)
+	}
+
+	if circuit.state == CircuitStateHalfOpen {
+		if circuit.trials >= b.halfOpenMaxRequests {
+			return false
+		}
+		circuit.trials++
+	}
+
+	return true
+}
+
+// IsAvailable returns whether Allow would let a request be sent to the given backend, without registering it
+func (b *CircuitBreakerT) IsAvailable(backend string) bool {
+	if !b.enabled {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	circuit, found := b.circuits[backend]
+	if !found {
+		return true
+	}
+
+	switch circuit.state {
+	case CircuitStateOpen:
+		return time.Since(circuit.openedAt) >= b.openTime
+	case CircuitStateHalfOpen:
+		return circuit.trials < b.halfOpenMaxRequests
+	}
+	return true
+}
+
+// Report registers the result of a request sent to the given backend.
+// Requests slower than the latency threshold, if any, are failures too
+func (b *CircuitBreakerT) Report(backend string, failed bool, latency time.Duration) {
+	if !b.enabled {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	circuit := b.getCircuit(backend)
+	if b.latencyThreshold > 0 && latency > b.latencyThreshold {
+		failed = true
+	}
+
+	switch circuit.state {
+	case CircuitStateClosed:
+		now := time.Now()
+		bucket := circuit.getBucket(now, b.window)
+		bucket.requests++
+		if failed {
+			bucket.failures++
+		}
+
+		requests, failures := circuit.countResults(now, b.window)
+		if requests >= b.minimumRequests && float64(failures)/float64(requests) >= b.errorRateThreshold {
+			b.open(backend, circuit)
+		}
+
+	// Any failed trial opens the circuit again
+	case CircuitStateHalfOpen:
+		if failed {
+			b.open(backend, circuit)
+			return
+		}
+
+		circuit.successes++
+		if circuit.successes >= b.halfOpenMaxRequests {
+			circuit.buckets = [circuitBreakerWindowBuckets]circuitBucketT{}
+			b.setState(backend, circuit, CircuitStateClosed)
+		}
+	}
+}
+
+// Cancel releases a request allowed for the given backend whose result is unknown,
+// as it was cancelled by the client
+func (b *CircuitBreakerT) Cancel(backend string) {
+	if !b.enabled {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	circuit := b.getCircuit(backend)
+	if circuit.state == CircuitStateHalfOpen && circuit.trials > circuit.successes {
+		circuit.trials--
+	}
+}
+
+// State returns the state of the circuit of the given backend
+func (b *CircuitBreakerT) State(backend string) string {
+	if !b.enabled {
+		return CircuitStateClosed
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	return b.getCircuit(backend).state
+}
+
+// getCircuit returns the circuit of the given backend, creating it closed when missing.
+// It must be called with the lock held
+func (b *CircuitBreakerT) getCircuit(backend string) *circuitT {
+	circuit, found := b.circuits[backend]
+	if !found {
+		circuit = &circuitT{state: CircuitStateClosed}
+		b.circuits[backend] = circuit
+	}
+	return circuit
+}
+
+// open opens the circuit of the given backend. It must be called with the lock held
+func (b *CircuitBreakerT) open(backend string, circuit *circuitT) {
+	circuit.openedAt = time.Now()
+	b.setState(backend, circuit, CircuitStateOpen)
+}
+
+// setState changes the state of the circuit, resetting the trials. It must be called with the lock held
+func (b *CircuitBreakerT) setState(backend string, circuit *circuitT, state string) {
+	circuit.state = state
+	circuit.trials = 0
+	circuit.successes = 0
+
+	if b.OnChange != nil {
+		b.OnChange(backend, state)
+	}
+}
+
+// getBucket returns the bucket of the window for the given time, resetting it when it is stale
+func (c *circuitT) getBucket(now time.Time, window time.Duration) *circuitBucketT {
+	epoch := now.UnixNano() / int64(window/circuitBreakerWindowBuckets)
+	bucket := &c.buckets[epoch%circuitBreakerWindowBuckets]
+
+	if bucket.epoch != epoch {
+		*bucket = circuitBucketT{epoch: epoch}
+	}
+	return bucket
+}
+
+// countResults returns the amount of requests, and the failed ones, inside the window ending at the given time
+func (c *circuitT) countResults(now time.Time, window time.Duration) (requests int, failures int) {
+	epoch := now.UnixNano() / int64(window/circuitBreakerWindowBuckets)
+
+	for _, bucket := range c.buckets {
+		if epoch-bucket.epoch < circuitBreakerWindowBuckets {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
+
+// getCircuitChangeHandler returns the function exposing the state changes of the circuits of the given route
+func (p *ProxyT) getCircuitChangeHandler(route *RouteT) func(backend string, state string) {
+	return func(backend string, state string) {
+		p.Meter.CircuitBreakerState.With(map[string]string{
+			"proxy_name": p.SelfConfig.Name,
+			"route":      route.Config.Name,
+			"backend":    backend,
+		}).Set(circuitStateValues[state])
+
+		if state == CircuitStateOpen {
+			route.Logger.Errorf("circuit breaker of server '%s' is %s", backend, state)
+			return
+		}
+		route.Logger.Infof("circuit breaker of server '%s' is %s", backend, state)
+	}
+}
@@ -6,50 +6,71 @@ package proxy
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	//
 )
 
 const (
-	RequestPartsPattern   = `\$\{REQUEST:([^\}]+)\}`
-	RequestHeaderPattern  = `\$\{REQUEST_HEADER:([^\}]+)\}`
-	ResponseHeaderPattern = `\$\{RESPONSE_HEADER:([^\}]+)\}`
-	ExtraPattern          = `\$\{EXTRA:([^\}]+)\}`
+	RequestPartsPattern       = `\$\{REQUEST:([^\}]+)\}`
+	RequestHeaderPattern      = `\$\{REQUEST_HEADER:([^\}]+)\}`
+	RequestCookiePattern      = `\$\{REQUEST_COOKIE:([^\}]+)\}`
+	RequestQueryPattern       = `\$\{REQUEST_QUERY:([^\}]+)\}`
+	RequestPathSegmentPattern = `\$\{REQUEST_PATH_SEGMENT:([^\}]+)\}`
+	ResponseHeaderPattern     = `\$\{RESPONSE_HEADER:([^\}]+)\}`
+	ExtraPattern              = `\$\{EXTRA:([^\}]+)\}`
 )
 
 var (
 	//
-	RequestPartsPatternCompiled    = regexp.MustCompile(RequestPartsPattern)
-	RequestHeadersPatternCompiled  = regexp.MustCompile(RequestHeaderPattern)
-	ResponseHeadersPatternCompiled = regexp.MustCompile(ResponseHeaderPattern)
-	ExtraPatternCompiled           = regexp.MustCompile(ExtraPattern)
+	RequestPartsPatternCompiled       = regexp.MustCompile(RequestPartsPattern)
+	RequestHeadersPatternCompiled     = regexp.MustCompile(RequestHeaderPattern)
+	RequestCookiesPatternCompiled     = regexp.MustCompile(RequestCookiePattern)
+	RequestQueryPatternCompiled       = regexp.MustCompile(RequestQueryPattern)
+	RequestPathSegmentPatternCompiled = regexp.MustCompile(RequestPathSegmentPattern)
+	ResponseHeadersPatternCompiled    = regexp.MustCompile(ResponseHeaderPattern)
+	ExtraPatternCompiled              = regexp.MustCompile(ExtraPattern)
 )
 
+// remoteIpContextKey is the key of the request context holding the IP of the client,
+// already resolved taking into account the trusted proxies
+type remoteIpContextKey struct{}
+
 // ConnectionExtraData represents internally autogenerated extra data for a connection.
 // It is used to replace the 'EXTRA' tags in the log message configuration
 type ConnectionExtraData struct {
-	RequestId string
-	Hashkey   string
-	Backend   string
+	RequestId  string
+	Route      string
+	Hashkey    string
+	Backend    string
+	Overflowed bool
+	Attempts   int
+
+	// State of the circuit breaker of the backend serving the request
+	CircuitBreaker string
 }
 
 // ReplaceRequestTags replaces the HTTP request tags in the given text
 // Tags are expressed as ${REQUEST:<part>}, where <part> can be one of the following:
-// scheme, host, port, path, query, method, proto
+// scheme, host, port, path, query, method, proto, remote_ip
+// As in the rest of replacing functions, missing values are replaced by empty strings
+// and unknown tags are kept untouched
 func ReplaceRequestTags(req *http.Request, textToProcess string) (result string) {
 
 	// Replace request parts in the format ${REQUEST:<part>}
 	requestTags := map[string]string{
-		"scheme": req.URL.Scheme,
-		"host":   req.Host,
-		"port":   req.URL.Port(),
-		"path":   req.URL.Path,
-		"query":  req.URL.RawQuery,
-		"method": req.Method,
-		"proto":  req.Proto,
+		"scheme":    req.URL.Scheme,
+		"host":      req.Host,
+		"port":      req.URL.Port(),
+		"path":      req.URL.Path,
+		"query":     req.URL.RawQuery,
+		"method":    req.Method,
+		"proto":     req.Proto,
+		"remote_ip": GetRemoteIp(req),
 	}
 
 	result = RequestPartsPatternCompiled.ReplaceAllStringFunc(textToProcess, func(match string) string {
@@ -60,7 +81,9 @@ func ReplaceRequestTags(req *http.Request, textToProcess string) (result string)
 		if replacement, exists := requestTags[variable]; exists {
 			return replacement
 		}
-		return textToProcess
+
+		// Unknown tags are kept untouched
+		return match
 	})
 
 	return result
@@ -75,12 +98,71 @@ func ReplaceRequestHeaderTags(req *http.Request, textToProcess string) (result s
 		variable := strings.ToLower(RequestHeadersPatternCompiled.FindStringSubmatch(match)[1])
 		headerValue := req.Header.Get(variable)
 
+		return headerValue
+	})
+
+	return result
+}
+
+// ReplaceRequestCookieTags replaces the HTTP request cookies in the given text
+// Tags are expressed as ${REQUEST_COOKIE:<cookie-name>}
+func ReplaceRequestCookieTags(req *http.Request, textToProcess string) (result string) {
+
+	result = RequestCookiesPatternCompiled.ReplaceAllStringFunc(textToProcess, func(match string) string {
+
+		variable := RequestCookiesPatternCompiled.FindStringSubmatch(match)[1]
+		cookie, err := req.Cookie(variable)
+
 		//
-		if headerValue == "" {
-			headerValue = textToProcess
+		if err != nil {
+			return ""
 		}
 
-		return headerValue
+		return cookie.Value
+	})
+
+	return result
+}
+
+// ReplaceRequestQueryTags replaces the HTTP request query parameters in the given text
+// Tags are expressed as ${REQUEST_QUERY:<parameter-name>}
+func ReplaceRequestQueryTags(req *http.Request, textToProcess string) (result string) {
+
+	result = RequestQueryPatternCompiled.ReplaceAllStringFunc(textToProcess, func(match string) string {
+
+		variable := RequestQueryPatternCompiled.FindStringSubmatch(match)[1]
+		parameterValue := req.URL.Query().Get(variable)
+
+		return parameterValue
+	})
+
+	return result
+}
+
+// ReplaceRequestPathSegmentTags replaces the HTTP request path segments in the given text
+// Tags are expressed as ${REQUEST_PATH_SEGMENT:<index>}, being 0 the first segment of the path.
+// Negative indexes count from the end, being -1 the last segment
+func ReplaceRequestPathSegmentTags(req *http.Request, textToProcess string) (result string) {
+
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	result = RequestPathSegmentPatternCompiled.ReplaceAllStringFunc(textToProcess, func(match string) string {
+
+		index, err := strconv.Atoi(RequestPathSegmentPatternCompiled.FindStringSubmatch(match)[1])
+		if err != nil {
+			return match
+		}
+
+		if index < 0 {
+			index += len(segments)
+		}
+
+		//
+		if index < 0 || index >= len(segments) {
+			return ""
+		}
+
+		return segments[index]
 	})
 
 	return result
@@ -95,11 +177,6 @@ func ReplaceResponseHeaderTags(res *http.Response, textToProcess string) (result
 		variable := strings.ToLower(ResponseHeadersPatternCompiled.FindStringSubmatch(match)[1])
 		headerValue := res.Header.Get(variable)
 
-		//
-		if headerValue == "" {
-			headerValue = textToProcess
-		}
-
 		return headerValue
 	})
 
@@ -108,7 +185,8 @@ func ReplaceResponseHeaderTags(res *http.Response, textToProcess string) (result
 
 // ReplaceExtraTags replaces the 'EXTRA' tags in the given text
 // Tags are expressed as ${EXTRA:<field-name>}
-// where <field-name> is one of the following: request-id, hashkey, backend
+// where <field-name> is one of the following: request-id, route, hashkey, backend, overflowed, attempts,
+// circuit-breaker
 func ReplaceExtraTags(extra ConnectionExtraData, textToProcess string) (result string) {
 
 	result = ExtraPatternCompiled.ReplaceAllStringFunc(textToProcess, func(match string) string {
@@ -118,12 +196,20 @@ func ReplaceExtraTags(extra ConnectionExtraData, textToProcess string) (result s
 		switch variable {
 		case "request-id":
 			return extra.RequestId
+		case "route":
+			return extra.Route
 		case "hashkey":
 			return extra.Hashkey
 		case "backend":
 			return extra.Backend
+		case "overflowed":
+			return strconv.FormatBool(extra.Overflowed)
+		case "attempts":
+			return strconv.Itoa(extra.Attempts)
+		case "circuit-breaker":
+			return extra.CircuitBreaker
 		default:
-			return textToProcess
+			return match
 		}
 	})
 
@@ -138,17 +224,23 @@ func GetRequestLogFields(req *http.Request, extraData ConnectionExtraData, confi
 
 		result := ReplaceRequestTags(req, field)
 		result = ReplaceRequestHeaderTags(req, result)
+		result = ReplaceRequestCookieTags(req, result)
+		result = ReplaceRequestQueryTags(req, result)
+		result = ReplaceRequestPathSegmentTags(req, result)
 		result = ReplaceExtraTags(extraData, result)
 
-		// Ignore not expanded fields
+		// Ignore not expanded or empty fields
 		cleanField := strings.ReplaceAll(field, " ", "")
-		if result == field && cleanField != "${REQUEST:body}" {
+		if (result == field || result == "") && cleanField != "${REQUEST:body}" {
 			continue
 		}
 
 		// Clean the field name a bit and add it to the fields pool
 		field = strings.TrimPrefix(field, "${REQUEST:")
 		field = strings.TrimPrefix(field, "${REQUEST_HEADER:")
+		field = strings.TrimPrefix(field, "${REQUEST_COOKIE:")
+		field = strings.TrimPrefix(field, "${REQUEST_QUERY:")
+		field = strings.TrimPrefix(field, "${REQUEST_PATH_SEGMENT:")
 		field = strings.TrimPrefix(field, "${EXTRA:")
 		field = strings.TrimSuffix(field, "}")
 
@@ -183,8 +275,8 @@ func GetResponseLogFields(res *http.Response, extraData ConnectionExtraData, con
 
 		result = ReplaceExtraTags(extraData, result)
 
-		// Ignore not expanded fields
-		if result == field {
+		// Ignore not expanded or empty fields
+		if result == field || result == "" {
 			continue
 		}
 
@@ -201,6 +293,84 @@ func GetResponseLogFields(res *http.Response, extraData ConnectionExtraData, con
 	return logFields
 }
 
+// ParseTrustedProxies parses a list of IPs or CIDRs into networks
+func ParseTrustedProxies(addresses []string) (networks []*net.IPNet, err error) {
+	for _, address := range addresses {
+
+		// Plain IPs are turned into single-host networks
+		if !strings.Contains(address, "/") {
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address '%s'", address)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address '%s': %s", address, err.Error())
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// IsTrustedProxy checks if the given IP belongs to any of the trusted networks
+func IsTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveRemoteIp returns the IP of the client that originated the request.
+// The X-Forwarded-For header is walked from right to left while the hops are trusted proxies,
+// so clients can not spoof their IP by adding entries to it
+func ResolveRemoteIp(req *http.Request, trustedProxies []*net.IPNet) string {
+
+	remoteIp, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteIp = req.RemoteAddr
+	}
+
+	forwardedIps := []string{}
+	for _, headerValue := range req.Header.Values("X-Forwarded-For") {
+		for _, forwardedIp := range strings.Split(headerValue, ",") {
+			forwardedIps = append(forwardedIps, strings.TrimSpace(forwardedIp))
+		}
+	}
+
+	for i := len(forwardedIps) - 1; i >= 0; i-- {
+		parsedRemoteIp := net.ParseIP(remoteIp)
+		if parsedRemoteIp == nil || !IsTrustedProxy(parsedRemoteIp, trustedProxies) {
+			break
+		}
+		remoteIp = forwardedIps[i]
+	}
+
+	return remoteIp
+}
+
+// GetRemoteIp returns the IP of the client stored in the request context by the proxy.
+// When it is not present, the IP of the direct peer is returned
+func GetRemoteIp(req *http.Request) string {
+	if remoteIp, ok := req.Context().Value(remoteIpContextKey{}).(string); ok {
+		return remoteIp
+	}
+	return ResolveRemoteIp(req, nil)
+}
+
 // IsIPv6 checks if the given IP address is an IPv6 address
 func IsIPv6(ip string) bool {
 	parsedIP := net.ParseIP(ip)
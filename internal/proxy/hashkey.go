@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"hashrouter/api"
+)
+
+const (
+
+	// Replacement used by transforms not defining one: keep the first capture group
+	defaultHashKeyTransformReplacement = "$1"
+
+	// Actions available for requests whose hash key is empty for every pattern
+	HashKeyOnEmptyActionStatus = "status"
+	HashKeyOnEmptyActionRandom = "random"
+	HashKeyOnEmptyActionFixed  = "fixed"
+
+	// Status code responded by 'status' action when not configured
+	defaultHashKeyOnEmptyStatusCode = http.StatusInternalServerError
+
+	// Level reported when no pattern produced a hash key
+	HashKeyLevelOnEmpty = "on_empty"
+)
+
+// HashKeyCalculatorT calculates the hash key of the requests according to the configuration
+type HashKeyCalculatorT struct {
+	patterns   []string
+	normalizer *HashKeyNormalizerT
+	transforms []hashKeyTransformT
+	onEmpty    api.HashKeyOnEmptyT
+}
+
+type hashKeyTransformT struct {
+	regex       *regexp.Regexp
+	replacement string
+}
+
+// NewHashKeyCalculator returns a HashKeyCalculatorT for the given configuration
+func NewHashKeyCalculator(config api.HashKeyT) (calculator *HashKeyCalculatorT, err error) {
+
+	calculator = &HashKeyCalculatorT{
+		onEmpty: config.OnEmpty,
+	}
+
+	// The single pattern is the first one of the chain
+	if config.Pattern != "" {
+		calculator.patterns = append(calculator.patterns, config.Pattern)
+	}
+	calculator.patterns = append(calculator.patterns, config.Patterns...)
+
+	if len(calculator.patterns) == 0 {
+		return nil, fmt.Errorf("hash_key pattern can not be empty")
+	}
+
+	switch calculator.onEmpty.Action {
+	case "":
+		calculator.onEmpty.Action = HashKeyOnEmptyActionStatus
+	case HashKeyOnEmptyActionStatus, HashKeyOnEmptyActionRandom:
+	case HashKeyOnEmptyActionFixed:
+		if calculator.onEmpty.Backend == "" {
+			return nil, fmt.Errorf("hash_key on_empty action '%s' requires a backend", HashKeyOnEmptyActionFixed)
+		}
+	default:
+		return nil, fmt.Errorf("unknown hash_key on_empty action '%s'", calculator.onEmpty.Action)
+	}
+
+	if calculator.onEmpty.StatusCode == 0 {
+		calculator.onEmpty.StatusCode = defaultHashKeyOnEmptyStatusCode
+	}
+
+	// Responses can only be written with valid status codes
+	if calculator.onEmpty.StatusCode < 100 || calculator.onEmpty.StatusCode > 599 {
+		return nil, fmt.Errorf("hash_key on_empty status code '%d' must be between 100 and 599",
+			calculator.onEmpty.StatusCode)
+	}
+
+	calculator.normalizer, err = NewHashKeyNormalizer(config.Normalize)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, transform := range config.Transforms {
+		regex, err := regexp.Compile(transform.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling hash_key transform regex '%s': %s", transform.Regex, err.Error())
+		}
+
+		replacement := transform.Replacement
+		if replacement == "" {
+			replacement = defaultHashKeyTransformReplacement
+		}
+
+		calculator.transforms = append(calculator.transforms, hashKeyTransformT{
+			regex:       regex,
+			replacement: replacement,
+		})
+	}
+
+	return calculator, nil
+}
+
+// Calculate returns the hash key for the given request, trying the patterns in order
+// until one of them produces a non-empty key. The level is the index of that pattern,
+// or HashKeyLevelOnEmpty when all of them produced an empty key
+func (c *HashKeyCalculatorT) Calculate(req *http.Request) (hashKey string, level string) {
+
+	for index, pattern := range c.patterns {
+		hashKey = c.calculatePattern(req, pattern)
+		if hashKey != "" {
+			return hashKey, strconv.Itoa(index)
+		}
+	}
+
+	return "", HashKeyLevelOnEmpty
+}
+
+// OnEmpty returns what to do with the requests whose hash key is empty for every pattern
+func (c *HashKeyCalculatorT) OnEmpty() api.HashKeyOnEmptyT {
+	return c.onEmpty
+}
+
+// calculatePattern returns the hash key for the given request and pattern.
+// Tags in the pattern are expanded first, then the key is normalized and the transforms are applied in order
+func (c *HashKeyCalculatorT) calculatePattern(req *http.Request, pattern string) (hashKey string) {
+
+	hashKey = ReplaceRequestTags(req, pattern)
+	hashKey = ReplaceRequestHeaderTags(req, hashKey)
+	hashKey = ReplaceRequestCookieTags(req, hashKey)
+	hashKey = ReplaceRequestQueryTags(req, hashKey)
+	hashKey = ReplaceRequestPathSegmentTags(req, hashKey)
+
+	hashKey = c.normalizer.Normalize(hashKey)
+
+	// Transforms keep only the expansion of their replacement when their regex matches the key.
+	// Keys not matching the regex are not modified
+	for _, transform := range c.transforms {
+		match := transform.regex.FindStringSubmatchIndex(hashKey)
+		if match == nil {
+			continue
+		}
+		hashKey = string(transform.regex.ExpandString(nil, transform.replacement, hashKey, match))
+	}
+
+	return strings.TrimSpace(hashKey)
+}
@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+
+	// Modes available to set the Host header of the requests sent to the backends
+	HostHeaderPreserve = "preserve"
+	HostHeaderBackend  = "backend"
+	HostHeaderFixed    = "fixed"
+
+	// Pseudonym of the proxy in the 'Via' header.
+	// (default: hashrouter)
+	defaultViaPseudonym = "hashrouter"
+)
+
+var (
+
+	// Headers meaningful only for a single connection, which must not be forwarded by proxies (RFC 9110, section 7.6.1)
+	hopByHopHeaders = []string{
+		"Connection",
+		"Proxy-Connection",
+		"Keep-Alive",
+		"Proxy-Authenticate",
+		"Proxy-Authorization",
+		"Te",
+		"Trailer",
+		"Transfer-Encoding",
+		"Upgrade",
+	}
+)
+
+// removeHopByHopHeaders removes the hop-by-hop headers from the given header,
+// including those listed in the 'Connection' header
+func removeHopByHopHeaders(header http.Header) {
+	for _, connectionValue := range header.Values("Connection") {
+		for _, connectionHeader := range strings.Split(connectionValue, ",") {
+			if connectionHeader = strings.TrimSpace(connectionHeader); connectionHeader != "" {
+				header.Del(connectionHeader)
+			}
+		}
+	}
+
+	for _, hopByHopHeader := range hopByHopHeaders {
+		header.Del(hopByHopHeader)
+	}
+}
+
+// getBackendRequestHeader returns the headers to be sent to the backend for the given request:
+// hop-by-hop headers are removed, and the forwarding headers are added.
+// Forwarding headers sent by trusted proxies are extended, while those sent by others are replaced
+func (p *ProxyT) getBackendRequestHeader(r *http.Request, route *RouteT) (header http.Header) {
+
+	header = r.Header.Clone()
+
+	// 'TE: trailers' is kept, as it's needed by the backends to send trailers (gRPC)
+	keepTeTrailers := httpguts.HeaderValuesContainsToken(r.Header["Te"], "trailers")
+	removeHopByHopHeaders(header)
+	if keepTeTrailers {
+		header.Set("Te", "trailers")
+	}
+
+	// An empty 'User-Agent' prevents the client from setting its own one when the request has none
+	if _, found := header["User-Agent"]; !found {
+		header.Set("User-Agent", "")
+	}
+
+	//
+	peerIp, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peerIp = r.RemoteAddr
+	}
+
+	parsedPeerIp := net.ParseIP(peerIp)
+	isTrustedPeer := parsedPeerIp != nil && IsTrustedProxy(parsedPeerIp, route.TrustedProxies)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	// X-Forwarded-*
+	if forwardedFor := r.Header.Values("X-Forwarded-For"); isTrustedPeer && len(forwardedFor) > 0 {
+		header.Set("X-Forwarded-For", strings.Join(forwardedFor, ", ")+", "+peerIp)
+	} else {
+		header.Set("X-Forwarded-For", peerIp)
+	}
+
+	if !isTrustedPeer || header.Get("X-Forwarded-Proto") == "" {
+		header.Set("X-Forwarded-Proto", scheme)
+	}
+
+	if !isTrustedPeer || header.Get("X-Forwarded-Host") == "" {
+		header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	// Forwarded (RFC 7239)
+	forwardedElement := fmt.Sprintf("for=%s;host=%s;proto=%s",
+		getForwardedNode(peerIp), getForwardedValue(r.Host), scheme)
+
+	if forwarded := r.Header.Values("Forwarded"); isTrustedPeer && len(forwarded) > 0 {
+		header.Set("Forwarded", strings.Join(forwarded, ", ")+", "+forwardedElement)
+	} else {
+		header.Set("Forwarded", forwardedElement)
+	}
+
+	// Via
+	if !isEnabled(route.Config.Options.DisableViaHeader) {
+		header.Add("Via", getViaValue(r.ProtoMajor, r.ProtoMinor, route.Config.Options.ViaPseudonym))
+	}
+
+	return header
+}
+
+// copyBackendResponseHeader copies the headers of the backend response into the client response,
+// keeping all the values of the repeated headers (such as 'Set-Cookie') and removing the hop-by-hop ones
+func (p *ProxyT) copyBackendResponseHeader(w http.ResponseWriter, resp *http.Response, route *RouteT) {
+
+	header := resp.Header.Clone()
+	removeHopByHopHeaders(header)
+
+	for key, values := range header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	if !isEnabled(route.Config.Options.DisableViaHeader) {
+		w.Header().Add("Via", getViaValue(resp.ProtoMajor, resp.ProtoMinor, route.Config.Options.ViaPseudonym))
+	}
+}
+
+// getBackendRequestHost returns the Host header sent to the backend for the given request.
+// An empty value sends the address of the backend
+func getBackendRequestHost(r *http.Request, route *RouteT) string {
+	switch route.Config.Options.HostHeader {
+	case HostHeaderPreserve:
+		return r.Host
+	case HostHeaderFixed:
+		return route.Config.Options.HostHeaderValue
+	default:
+		return ""
+	}
+}
+
+// announceBackendResponseTrailers announces the trailers declared by the backend response,
+// so they can be sent to the client once the body is copied. It must be called before writing the headers
+func announceBackendResponseTrailers(w http.ResponseWriter, resp *http.Response) (announced []string) {
+	for key := range resp.Trailer {
+		w.Header().Add("Trailer", key)
+		announced = append(announced, key)
+	}
+	return announced
+}
+
+// copyBackendResponseTrailers copies the trailers of the backend response into the client response.
+// Trailers not announced before are sent using the trailer prefix of the Go standard library.
+// It must be called once the body is completely read
+func copyBackendResponseTrailers(w http.ResponseWriter, resp *http.Response, announced []string) {
+	for key, values := range resp.Trailer {
+		if !slices.Contains(announced, key) {
+			key = http.TrailerPrefix + key
+		}
+
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+}
+
+// getViaValue returns the value added to the 'Via' header for a message of the given protocol version
+func getViaValue(protoMajor int, protoMinor int, pseudonym string) string {
+	if pseudonym == "" {
+		pseudonym = defaultViaPseudonym
+	}
+
+	if protoMajor >= 2 {
+		return fmt.Sprintf("%d %s", protoMajor, pseudonym)
+	}
+	return fmt.Sprintf("%d.%d %s", protoMajor, protoMinor, pseudonym)
+}
+
+// getForwardedNode returns the given IP formatted as a node of the 'Forwarded' header.
+// IPv6 addresses are enclosed in brackets and quoted
+func getForwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return getForwardedValue(ip)
+}
+
+// getForwardedValue returns the given value quoted when it's not a valid token for the 'Forwarded' header
+func getForwardedValue(value string) string {
+	for _, char := range value {
+		if !httpguts.IsTokenRune(char) {
+			return fmt.Sprintf("%q", value)
+		}
+	}
+	return value
+}
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"hashrouter/api"
+)
+
+// newTestRoute returns a route with the given options, trusting the given proxies
+func newTestRoute(t *testing.T, options api.OptionsT, trustedProxies ...string) *RouteT {
+	t.Helper()
+
+	networks, err := ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &RouteT{
+		Config:         api.RouteT{Options: options},
+		TrustedProxies: networks,
+	}
+}
+
+// TestBackendRequestHeader checks the headers sent to the backends
+func TestBackendRequestHeader(t *testing.T) {
+	disabled := true
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		header         http.Header
+		options        api.OptionsT
+		trustedProxies []string
+
+		expected http.Header
+		removed  []string
+	}{
+		{
+			name:       "hop-by-hop and connection headers removed",
+			remoteAddr: "192.0.2.10:1234",
+			header: http.Header{
+				"Connection":       {"keep-alive, X-Internal-Token", "X-Other"},
+				"Keep-Alive":       {"timeout=5"},
+				"Upgrade":          {"websocket"},
+				"X-Internal-Token": {"secret"},
+				"X-Other":          {"value"},
+				"Te":               {"trailers, deflate"},
+				"Accept":           {"text/html"},
+			},
+			expected: http.Header{
+				"Accept": {"text/html"},
+				"Te":     {"trailers"},
+			},
+			removed: []string{"Connection", "Keep-Alive", "Upgrade", "X-Internal-Token", "X-Other"},
+		},
+		{
+			name:       "untrusted peer replaces forwarding headers",
+			remoteAddr: "192.0.2.10:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"spoofed.example.com"},
+				"Forwarded":         {"for=203.0.113.1"},
+			},
+			expected: http.Header{
+				"X-Forwarded-For":   {"192.0.2.10"},
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"example.com"},
+				"Forwarded":         {"for=192.0.2.10;host=example.com;proto=http"},
+				"Via":               {"1.1 hashrouter"},
+			},
+		},
+		{
+			name:           "trusted peer extends forwarding headers",
+			remoteAddr:     "10.0.0.5:1234",
+			trustedProxies: []string{"10.0.0.0/8"},
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.1", "198.51.100.7"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"public.example.com"},
+				"Forwarded":         {"for=203.0.113.1;proto=https"},
+				"Via":               {"1.1 edge"},
+			},
+			expected: http.Header{
+				"X-Forwarded-For":   {"203.0.113.1, 198.51.100.7, 10.0.0.5"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"public.example.com"},
+				"Forwarded":         {"for=203.0.113.1;proto=https, for=10.0.0.5;host=example.com;proto=http"},
+				"Via":               {"1.1 edge", "1.1 hashrouter"},
+			},
+		},
+		{
+			name:       "IPv6 peer and custom via pseudonym",
+			remoteAddr: "[2001:db8::1]:1234",
+			options:    api.OptionsT{ViaPseudonym: "cache-01"},
+			expected: http.Header{
+				"X-Forwarded-For": {"2001:db8::1"},
+				"Forwarded":       {`for="[2001:db8::1]";host=example.com;proto=http`},
+				"Via":             {"1.1 cache-01"},
+			},
+		},
+		{
+			name:       "via header disabled",
+			remoteAddr: "192.0.2.10:1234",
+			options:    api.OptionsT{DisableViaHeader: &disabled},
+			removed:    []string{"Via"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			req.RemoteAddr = test.remoteAddr
+			for key, values := range test.header {
+				req.Header[key] = values
+			}
+
+			header := (&ProxyT{}).getBackendRequestHeader(req, newTestRoute(t, test.options, test.trustedProxies...))
+
+			for key, values := range test.expected {
+				if !slices.Equal(header.Values(key), values) {
+					t.Errorf("header '%s': got %q, want %q", key, header.Values(key), values)
+				}
+			}
+
+			for _, key := range test.removed {
+				if _, found := header[http.CanonicalHeaderKey(key)]; found {
+					t.Errorf("header '%s' was not removed: %q", key, header.Values(key))
+				}
+			}
+		})
+	}
+}
+
+// TestBackendResponseHeader checks the headers of the backend responses sent to the clients
+func TestBackendResponseHeader(t *testing.T) {
+	resp := &http.Response{
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Set-Cookie":      {"session=abc; Path=/", "theme=dark; Path=/"},
+			"Connection":      {"X-Backend-Debug"},
+			"X-Backend-Debug": {"on"},
+			"Keep-Alive":      {"timeout=5"},
+			"Content-Type":    {"text/html"},
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	(&ProxyT{}).copyBackendResponseHeader(recorder, resp, newTestRoute(t, api.OptionsT{}))
+	header := recorder.Header()
+
+	expected := http.Header{
+		"Set-Cookie":   {"session=abc; Path=/", "theme=dark; Path=/"},
+		"Content-Type": {"text/html"},
+		"Via":          {"1.1 hashrouter"},
+	}
+	for key, values := range expected {
+		if !slices.Equal(header.Values(key), values) {
+			t.Errorf("header '%s': got %q, want %q", key, header.Values(key), values)
+		}
+	}
+
+	for _, key := range []string{"Connection", "X-Backend-Debug", "Keep-Alive"} {
+		if _, found := header[key]; found {
+			t.Errorf("header '%s' was not removed: %q", key, header.Values(key))
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"hashrouter/api"
+	"hashrouter/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+
+	// Maximum amount of health checks performed at the same time for a route.
+	// (default: 16)
+	defaultHealthCheckConcurrency = 16
+
+	// Fraction of the interval added randomly between probes, to spread them over time
+	healthCheckIntervalJitter = 0.1
+
+	// Consecutive checks needed to change the health of a backend
+	// (default: 1)
+	defaultHealthCheckThreshold = 1
+
+	// Time a flapping backend must keep the same state to be released
+	// (default: 5m)
+	defaultHealthCheckFlapStableTime = 5 * time.Minute
+
+	// States reported on transitions
+	HealthStateHealthy   = "healthy"
+	HealthStateUnhealthy = "unhealthy"
+	HealthStateFlapping  = "flapping"
+	HealthStateStable    = "stable"
+)
+
+// HealthCheckerT checks the health of the backends, running an independent prober for each one of them.
+// Probers run concurrently, bounded by a maximum amount of simultaneous checks.
+// The latest result of each backend is kept to be read by the synchronizer
+type HealthCheckerT struct {
+	sync.RWMutex
+
+	//
+	probers         map[string]*healthProberT
+	semaphore       chan struct{}
+	defaultInterval time.Duration
+	client          *http.Client
+	insecureClient  *http.Client
+
+	// Changes notifies that the health of any backend changed. Notifications are coalesced
+	changes chan struct{}
+
+	//
+	proxyName string
+	routeName string
+	Logger    *zap.SugaredLogger
+	Meter     *metrics.PoolT
+}
+
+// healthProberT holds the state of the prober of a single backend
+type healthProberT struct {
+	address string
+	config  api.HealthCheckT
+	spec    *healthCheckSpecT
+	stop    chan struct{}
+
+	// Protected by the lock of the HealthCheckerT
+	checked   bool
+	healthy   bool
+	latency   time.Duration
+	successes int
+	failures  int
+
+	// Time of the latest transition, and those happened inside the flap detection window
+	stateSince  time.Time
+	transitions []time.Time
+	flapping    bool
+}
+
+// NewHealthChecker returns a HealthCheckerT for a route.
+// Backends not configuring their interval are checked every 'defaultInterval'
+func NewHealthChecker(proxyName string, routeName string, concurrency int, defaultInterval time.Duration,
+	logger *zap.SugaredLogger, meter *metrics.PoolT) *HealthCheckerT {
+
+	if concurrency <= 0 {
+		concurrency = defaultHealthCheckConcurrency
+	}
+
+	client, insecureClient := newHealthCheckClients()
+
+	return &HealthCheckerT{
+		probers:         make(map[string]*healthProberT),
+		semaphore:       make(chan struct{}, concurrency),
+		defaultInterval: defaultInterval,
+		client:          client,
+		insecureClient:  insecureClient,
+		changes:         make(chan struct{}, 1),
+		proxyName:       proxyName,
+		routeName:       routeName,
+		Logger:          logger,
+		Meter:           meter,
+	}
+}
+
+// validateHealthCheck returns an error when the given health check configuration is not valid
+func validateHealthCheck(config api.HealthCheckT) error {
+
+	if config.HealthyThreshold < 0 || config.UnhealthyThreshold < 0 {
+		return fmt.Errorf("thresholds can not be negative")
+	}
+
+	if config.FlapDetection.Transitions > 0 && config.FlapDetection.Window <= 0 {
+		return fmt.Errorf("flap detection requires a window")
+	}
+
+	if config.FlapDetection.StableTime < 0 {
+		return fmt.Errorf("flap detection stable time can not be negative")
+	}
+
+	_, err := newHealthCheckSpec(config)
+	return err
+}
+
+// Changes returns a channel receiving a notification when the health of any backend changes
+func (c *HealthCheckerT) Changes() <-chan struct{} {
+	return c.changes
+}
+
+// Update sets the backends to be checked. Probers are started for the new backends,
+// and stopped for the missing ones. Backends without health check configuration are not checked
+func (c *HealthCheckerT) Update(backends []BackendT) {
+	c.Lock()
+	defer c.Unlock()
+
+	wanted := make(map[string]api.HealthCheckT)
+	for _, backend := range backends {
+		if reflect.ValueOf(backend.Health).IsZero() {
+			continue
+		}
+		wanted[backend.Host] = backend.Health
+	}
+
+	for address, prober := range c.probers {
+		if config, found := wanted[address]; found && reflect.DeepEqual(config, prober.config) {
+			continue
+		}
+
+		close(prober.stop)
+		delete(c.probers, address)
+
+		// Series of the backends not checked anymore are deleted, so those replaced over time do not keep them
+		if _, found := wanted[address]; !found {
+			labels := prometheus.Labels{
+				"proxy_name": c.proxyName,
+				"route":      c.routeName,
+				"backend":    address,
+			}
+			c.Meter.HealthCheckDuration.DeletePartialMatch(labels)
+			c.Meter.HealthTransitionsTotal.DeletePartialMatch(labels)
+		}
+	}
+
+	for address, config := range wanted {
+		if _, found := c.probers[address]; found {
+			continue
+		}
+
+		// Configurations are validated when the route is created, so this should not happen
+		spec, err := newHealthCheckSpec(config)
+		if err != nil {
+			c.Logger.Errorf("error creating healthcheck for host '%s': %s", address, err.Error())
+			continue
+		}
+		spec.client = c.getProbeClient(config)
+
+		prober := &healthProberT{
+			address: address,
+			config:  config,
+			spec:    spec,
+			stop:    make(chan struct{}),
+		}
+		c.probers[address] = prober
+
+		go c.runProber(prober)
+	}
+}
+
+// IsHealthy returns whether the backend at the given address is healthy and not flapping.
+// Backends not checked yet are considered unhealthy
+func (c *HealthCheckerT) IsHealthy(address string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	prober, found := c.probers[address]
+	return found && prober.isHealthy()
+}
+
+// isHealthy returns whether the backend can be part of the hashring.
+// It must be called with the lock of the HealthCheckerT held
+func (p *healthProberT) isHealthy() bool {
+	return p.checked && p.healthy && !p.flapping
+}
+
+// runProber checks the health of a backend periodically until the prober is stopped.
+// The first check is performed immediately, so new backends are added as soon as possible
+func (c *HealthCheckerT) runProber(prober *healthProberT) {
+
+	interval := c.defaultInterval
+	if prober.config.Interval > 0 {
+		interval = prober.config.Interval
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-prober.stop:
+			return
+		case <-timer.C:
+		}
+
+		// Wait for a free slot, as the amount of simultaneous checks is bounded
+		select {
+		case <-prober.stop:
+			return
+		case c.semaphore <- struct{}{}:
+		}
+
+		healthy, latency := c.probe(prober)
+		<-c.semaphore
+
+		c.setResult(prober, healthy, latency)
+
+		jitter := time.Duration(rand.Float64() * healthCheckIntervalJitter * float64(interval))
+		timer.Reset(interval + jitter)
+	}
+}
+
+// probe checks the health of the backend, trying up to the configured retries.
+// It returns the result and the latency of the last try
+func (c *HealthCheckerT) probe(prober *healthProberT) (healthy bool, latency time.Duration) {
+
+	for i := 0; i < max(prober.config.Retries, 1); i++ {
+		start := time.Now()
+		err := c.probeOnce(prober.spec, prober.address)
+		latency = time.Since(start)
+
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+
+		// Stopped probers do not report, as their series may be deleted already
+		c.RLock()
+		if c.probers[prober.address] == prober {
+			c.Meter.HealthCheckDuration.With(map[string]string{
+				"proxy_name": c.proxyName,
+				"route":      c.routeName,
+				"backend":    prober.address,
+				"result":     result,
+			}).Observe(latency.Seconds())
+		}
+		c.RUnlock()
+
+		if err == nil {
+			return true, latency
+		}
+
+		c.Logger.Debugf("healthcheck failed for host '%s': %s", prober.address, err.Error())
+	}
+
+	return false, latency
+}
+
+// setResult stores the result of a check. The health of the backend changes once the configured amount
+// of consecutive checks agree, and it has been in its current state for the minimum time.
+// Backends changing too often are held as flapping until they are stable again
+func (c *HealthCheckerT) setResult(prober *healthProberT, healthy bool, latency time.Duration) {
+	c.Lock()
+
+	// Results of stopped probers are discarded
+	if c.probers[prober.address] != prober {
+		c.Unlock()
+		return
+	}
+
+	now := time.Now()
+	config := prober.config
+	wasHealthy := prober.isHealthy()
+
+	state := HealthStateUnhealthy
+	if healthy {
+		state = HealthStateHealthy
+	}
+	states := []string{}
+
+	prober.latency = latency
+	if healthy {
+		prober.successes++
+		prober.failures = 0
+	} else {
+		prober.failures++
+		prober.successes = 0
+	}
+
+	switch {
+
+	// The first check sets the initial state, so new backends are added as soon as possible
+	case !prober.checked:
+		prober.checked = true
+		prober.healthy = healthy
+		prober.stateSince = now
+		states = append(states, state)
+
+	case prober.healthy != healthy:
+		threshold := config.HealthyThreshold
+		consecutive := prober.successes
+		if !healthy {
+			threshold = config.UnhealthyThreshold
+			consecutive = prober.failures
+		}
+		if threshold <= 0 {
+			threshold = defaultHealthCheckThreshold
+		}
+
+		if consecutive < threshold || now.Sub(prober.stateSince) < config.MinTimeInState {
+			break
+		}
+
+		prober.healthy = healthy
+		prober.stateSince = now
+		states = append(states, state)
+
+		// Transitions older than the window are forgotten
+		if config.FlapDetection.Transitions > 0 {
+			prober.transitions = append(prober.transitions, now)
+			prober.transitions = slices.DeleteFunc(prober.transitions, func(transition time.Time) bool {
+				return now.Sub(transition) > config.FlapDetection.Window
+			})
+
+			if !prober.flapping && len(prober.transitions) >= config.FlapDetection.Transitions {
+				prober.flapping = true
+				states = append(states, HealthStateFlapping)
+			}
+		}
+	}
+
+	// Flapping backends are released once they keep the same state for long enough
+	stableTime := defaultHealthCheckFlapStableTime
+	if config.FlapDetection.StableTime > 0 {
+		stableTime = config.FlapDetection.StableTime
+	}
+
+	if prober.flapping && now.Sub(prober.stateSince) >= stableTime {
+		prober.flapping = false
+		prober.transitions = nil
+		states = append(states, HealthStateStable)
+	}
+
+	// Transitions are reported with the lock held, so they are not reported once the prober is stopped
+	for _, state := range states {
+		c.Meter.HealthTransitionsTotal.With(map[string]string{
+			"proxy_name": c.proxyName,
+			"route":      c.routeName,
+			"backend":    prober.address,
+			"state":      state,
+		}).Inc()
+	}
+
+	isHealthy := prober.isHealthy()
+	c.Unlock()
+
+	for _, state := range states {
+		switch state {
+		case HealthStateHealthy, HealthStateStable:
+			c.Logger.Infof("backend '%s' is %s (latency: %s)", prober.address, state, latency)
+		default:
+			c.Logger.Errorf("backend '%s' is %s (latency: %s)", prober.address, state, latency)
+		}
+	}
+
+	if wasHealthy == isHealthy {
+		return
+	}
+
+	select {
+	case c.changes <- struct{}{}:
+	default:
+	}
+}
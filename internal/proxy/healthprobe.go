@@ -0,0 +1,253 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"hashrouter/api"
+)
+
+const (
+
+	// Types of health checks
+	HealthCheckTypeHttp  = "http"
+	HealthCheckTypeHttps = "https"
+	HealthCheckTypeTcp   = "tcp"
+
+	// Maximum bytes of the response body read to look for the expected content
+	healthCheckBodyLimit = 64 << 10
+)
+
+// healthCheckSpecT is the compiled version of a health check configuration
+type healthCheckSpecT struct {
+	config    api.HealthCheckT
+	statuses  []statusRangeT
+	bodyRegex *regexp.Regexp
+
+	// Client sending the HTTP checks
+	client *http.Client
+}
+
+// statusRangeT is an inclusive range of HTTP status codes
+type statusRangeT struct {
+	from int
+	to   int
+}
+
+// newHealthCheckSpec returns the compiled version of the given health check configuration
+func newHealthCheckSpec(config api.HealthCheckT) (spec *healthCheckSpecT, err error) {
+
+	spec = &healthCheckSpecT{
+		config: config,
+	}
+
+	switch config.Type {
+	case "", HealthCheckTypeHttp, HealthCheckTypeHttps, HealthCheckTypeTcp:
+	default:
+		return nil, fmt.Errorf("unknown healthcheck type '%s'", config.Type)
+	}
+
+	// Only the status 200 is expected when nothing is configured
+	if len(config.ExpectedStatuses) == 0 {
+		spec.statuses = []statusRangeT{{from: http.StatusOK, to: http.StatusOK}}
+	}
+
+	for _, expression := range config.ExpectedStatuses {
+		statusRange, err := parseStatusRange(expression)
+		if err != nil {
+			return nil, err
+		}
+		spec.statuses = append(spec.statuses, statusRange)
+	}
+
+	if config.ExpectedBodyRegex != "" {
+		spec.bodyRegex, err = regexp.Compile(config.ExpectedBodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling expected body regex '%s': %s", config.ExpectedBodyRegex, err.Error())
+		}
+	}
+
+	return spec, nil
+}
+
+// parseStatusRange parses a status code ('200') or an inclusive range of them ('200-399')
+func parseStatusRange(expression string) (statusRange statusRangeT, err error) {
+
+	from, to, isRange := strings.Cut(strings.TrimSpace(expression), "-")
+	if !isRange {
+		to = from
+	}
+
+	statusRange.from, err = strconv.Atoi(strings.TrimSpace(from))
+	if err == nil {
+		statusRange.to, err = strconv.Atoi(strings.TrimSpace(to))
+	}
+
+	if err != nil || statusRange.from < 100 || statusRange.to > 599 || statusRange.from > statusRange.to {
+		return statusRange, fmt.Errorf("invalid expected status '%s'", expression)
+	}
+
+	return statusRange, nil
+}
+
+// probeOnce performs a single health check against the given address
+func (c *HealthCheckerT) probeOnce(spec *healthCheckSpecT, address string) error {
+
+	ctx := context.Background()
+	if spec.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, spec.config.Timeout)
+		defer cancel()
+	}
+
+	if spec.config.Type == HealthCheckTypeTcp {
+		return probeTcp(ctx, address)
+	}
+
+	return c.probeHttp(ctx, spec, address)
+}
+
+// probeTcp checks that a connection can be established with the given address
+func probeTcp(ctx context.Context, address string) error {
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// probeHttp checks that the response of the backend meets the expected status and body
+func (c *HealthCheckerT) probeHttp(ctx context.Context, spec *healthCheckSpecT, address string) error {
+
+	scheme := HealthCheckTypeHttp
+	if spec.config.Type == HealthCheckTypeHttps {
+		scheme = HealthCheckTypeHttps
+	}
+
+	url := fmt.Sprintf("%s://%s%s", scheme, address, spec.config.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range spec.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if spec.config.Host != "" {
+		req.Host = spec.config.Host
+	}
+
+	resp, err := spec.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// The rest of the body is drained, so the connection can be reused by the next checks
+	defer func() {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, healthCheckBodyLimit))
+		resp.Body.Close()
+	}()
+
+	statusExpected := false
+	for _, statusRange := range spec.statuses {
+		if resp.StatusCode >= statusRange.from && resp.StatusCode <= statusRange.to {
+			statusExpected = true
+			break
+		}
+	}
+
+	if !statusExpected {
+		return fmt.Errorf("unexpected status '%s'", resp.Status)
+	}
+
+	if spec.config.ExpectedBody == "" && spec.bodyRegex == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, healthCheckBodyLimit))
+	if err != nil {
+		return fmt.Errorf("error reading body: %s", err.Error())
+	}
+
+	if spec.config.ExpectedBody != "" && !strings.Contains(string(body), spec.config.ExpectedBody) {
+		return fmt.Errorf("body does not contain '%s'", spec.config.ExpectedBody)
+	}
+
+	if spec.bodyRegex != nil && !spec.bodyRegex.Match(body) {
+		return fmt.Errorf("body does not match '%s'", spec.config.ExpectedBodyRegex)
+	}
+
+	return nil
+}
+
+// newHealthCheckClients returns the clients used by the HTTP checks,
+// the second one skipping the verification of the backend certificates.
+// Redirects are not followed, so the status of the backend itself is checked
+func newHealthCheckClients() (client *http.Client, insecureClient *http.Client) {
+
+	insecureTransport := http.DefaultTransport.(*http.Transport).Clone()
+	insecureTransport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: true,
+	}
+
+	checkRedirect := func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	client = &http.Client{
+		Transport:     http.DefaultTransport.(*http.Transport).Clone(),
+		CheckRedirect: checkRedirect,
+	}
+
+	insecureClient = &http.Client{
+		Transport:     insecureTransport,
+		CheckRedirect: checkRedirect,
+	}
+
+	return client, insecureClient
+}
+
+// getProbeClient returns the client sending the HTTP checks of the given configuration.
+// Backends are usually dialed by IP, so HTTPS checks setting the host get their own transport
+// sending it as server name, to verify the certificate against it
+func (c *HealthCheckerT) getProbeClient(config api.HealthCheckT) *http.Client {
+
+	client := c.client
+	if config.InsecureSkipVerify {
+		client = c.insecureClient
+	}
+
+	if config.Type != HealthCheckTypeHttps || config.Host == "" {
+		return client
+	}
+
+	serverName := config.Host
+	if host, _, err := net.SplitHostPort(config.Host); err == nil {
+		serverName = host
+	}
+
+	transport := client.Transport.(*http.Transport).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.ServerName = serverName
+
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: client.CheckRedirect,
+	}
+}
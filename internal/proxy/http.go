@@ -5,14 +5,17 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"slices"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 )
@@ -49,6 +52,22 @@ const (
 	// Disable keep alives to the backend.
 	// (default: false)
 	defaultHttpBackendDisableKeepAlives = false
+
+	// Maximum idle connections kept open to each backend.
+	// (default: 100)
+	defaultHttpBackendMaxIdleConnsPerBackend = 100
+
+	// Maximum connections open to each backend, including the busy ones.
+	// (default: 0 [no limit])
+	defaultHttpBackendMaxConnsPerBackend = 0
+
+	// Time in milliseconds an idle connection is kept open before closing it.
+	// (default: 90s)
+	defaultHttpBackendIdleConnTimeoutMillis = 90000
+
+	// Maximum time in milliseconds to wait for the backend response headers once the request is sent.
+	// (default: 0ms [no timeout])
+	defaultHttpBackendResponseHeaderTimeoutMillis = 0
 )
 
 // writeDirectResponse writes a static response.
@@ -104,39 +123,84 @@ func (p *ProxyT) getConfiguredHttpServer(addr string, handler http.Handler) (ser
 	return server
 }
 
-// getConfiguredHttpClient returns an HTTP client already configured according to the proxy configuration
-func (p *ProxyT) getConfiguredHttpClient() *http.Client {
+// getConfiguredHttpClient returns an HTTP client already configured according to the route options.
+// The client is shared by all the requests of the route, so connections to the backends are reused
+func (p *ProxyT) getConfiguredHttpClient(route *RouteT) *http.Client {
+
+	options := route.Config.Options
 
 	requestTimeout := defaultHttpBackendRequestTimeoutMillis * time.Millisecond
-	if p.SelfConfig.Options.HttpBackendRequestTimeoutMillis > 0 {
-		requestTimeout = time.Duration(p.SelfConfig.Options.HttpBackendRequestTimeoutMillis) * time.Millisecond
+	if options.HttpBackendRequestTimeoutMillis > 0 {
+		requestTimeout = time.Duration(options.HttpBackendRequestTimeoutMillis) * time.Millisecond
 	}
 
 	dialTimeout := defaultHttpBackendDialTimeoutMillis * time.Millisecond
-	if p.SelfConfig.Options.HttpBackendDialTimeoutMillis > 0 {
-		dialTimeout = time.Duration(p.SelfConfig.Options.HttpBackendDialTimeoutMillis) * time.Millisecond
+	if options.HttpBackendDialTimeoutMillis > 0 {
+		dialTimeout = time.Duration(options.HttpBackendDialTimeoutMillis) * time.Millisecond
 	}
 
 	//
 	keepAlive := defaultHttpBackendKeepAliveMillis * time.Millisecond
-	if p.SelfConfig.Options.HttpBackendKeepAliveMillis > 0 {
-		keepAlive = time.Duration(p.SelfConfig.Options.HttpBackendKeepAliveMillis) * time.Millisecond
+	if options.HttpBackendKeepAliveMillis > 0 {
+		keepAlive = time.Duration(options.HttpBackendKeepAliveMillis) * time.Millisecond
 	}
 
 	//
 	disableKeepAlives := defaultHttpBackendDisableKeepAlives
-	if p.SelfConfig.Options.HttpBackendDisableKeepAlives {
+	if isEnabled(options.HttpBackendDisableKeepAlives) {
 		disableKeepAlives = true
 	}
 
+	//
+	maxIdleConnsPerBackend := defaultHttpBackendMaxIdleConnsPerBackend
+	if options.HttpBackendMaxIdleConnsPerBackend > 0 {
+		maxIdleConnsPerBackend = options.HttpBackendMaxIdleConnsPerBackend
+	}
+
+	maxConnsPerBackend := defaultHttpBackendMaxConnsPerBackend
+	if options.HttpBackendMaxConnsPerBackend > 0 {
+		maxConnsPerBackend = options.HttpBackendMaxConnsPerBackend
+	}
+
+	idleConnTimeout := defaultHttpBackendIdleConnTimeoutMillis * time.Millisecond
+	if options.HttpBackendIdleConnTimeoutMillis > 0 {
+		idleConnTimeout = time.Duration(options.HttpBackendIdleConnTimeoutMillis) * time.Millisecond
+	}
+
+	responseHeaderTimeout := defaultHttpBackendResponseHeaderTimeoutMillis * time.Millisecond
+	if options.HttpBackendResponseHeaderTimeoutMillis > 0 {
+		responseHeaderTimeout = time.Duration(options.HttpBackendResponseHeaderTimeoutMillis) * time.Millisecond
+	}
+
+	//
+	dialContext := getTrackedDialContext(route, &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: keepAlive,
+	})
+
+	// Compression is negotiated by the clients, so responses are forwarded as they are.
+	// HTTP/2 transports keep a single multiplexed connection per backend
+	if options.HttpBackendProtocol == BackendProtocolH2 || options.HttpBackendProtocol == BackendProtocolH2c {
+		http2Transport := getHttp2Transport(options, dialContext)
+		http2Transport.IdleConnTimeout = idleConnTimeout
+		http2Transport.DisableCompression = true
+
+		return &http.Client{
+			Timeout:   requestTimeout,
+			Transport: http2Transport,
+		}
+	}
+
 	return &http.Client{
 		Timeout: requestTimeout,
 		Transport: &http.Transport{
-			DisableKeepAlives: disableKeepAlives,
-			DialContext: (&net.Dialer{
-				Timeout:   dialTimeout,
-				KeepAlive: keepAlive,
-			}).DialContext,
+			DisableKeepAlives:     disableKeepAlives,
+			DisableCompression:    true,
+			DialContext:           dialContext,
+			MaxIdleConnsPerHost:   maxIdleConnsPerBackend,
+			MaxConnsPerHost:       maxConnsPerBackend,
+			IdleConnTimeout:       idleConnTimeout,
+			ResponseHeaderTimeout: responseHeaderTimeout,
 		},
 	}
 }
@@ -147,6 +211,7 @@ func (p *ProxyT) HTTPHandleFunc(w http.ResponseWriter, r *http.Request) {
 	//
 	httpRequestsTotalMetricLabels := map[string]string{
 		"proxy_name": p.SelfConfig.Name,
+		"route":      "none",
 		"method":     r.Method,
 	}
 
@@ -166,43 +231,159 @@ func (p *ProxyT) HTTPHandleFunc(w http.ResponseWriter, r *http.Request) {
 	requestId := generateRandToken()
 	connectionExtraData.RequestId = requestId
 
-	// calculate hashkey
-	hashKey := ReplaceRequestTags(r, p.SelfConfig.HashKey.Pattern)
-	hashKey = ReplaceRequestHeaderTags(r, hashKey)
-	hashKey = strings.TrimSpace(hashKey)
-	if len(hashKey) == 0 {
-		p.Logger.Error("error calculating hash_key: can not be empty")
+	// The first route matching the request serves it
+	route := p.GetRoute(r)
+	if route == nil {
+		p.Logger.Debugf("no route matching request '%s %s%s'", r.Method, r.Host, r.URL.Path)
 
-		httpRequestsTotalMetricLabels["delivered_status_code"] = strconv.Itoa(http.StatusInternalServerError)
-		httpRequestsTotalMetricLabels["error"] = "hash_key_calculation_failed"
+		httpRequestsTotalMetricLabels["delivered_status_code"] = strconv.Itoa(http.StatusNotFound)
+		httpRequestsTotalMetricLabels["error"] = "route_not_found"
 
-		writeDirectResponse(w, http.StatusInternalServerError, "Internal Server Error")
+		writeDirectResponse(w, http.StatusNotFound, "Not Found")
 		return
 	}
+	httpRequestsTotalMetricLabels["route"] = route.Config.Name
+	connectionExtraData.Route = route.Config.Name
+
+	// Resolve the client IP once, so it's available for the hash key and the logs
+	r = r.WithContext(context.WithValue(r.Context(), remoteIpContextKey{}, ResolveRemoteIp(r, route.TrustedProxies)))
+
+	// calculate hashkey
+	hashKey, hashKeyLevel := route.HashKey.Calculate(r)
+	p.Meter.HashKeyFallbackLevelTotal.With(map[string]string{
+		"proxy_name": p.SelfConfig.Name,
+		"route":      route.Config.Name,
+		"level":      hashKeyLevel,
+	}).Add(1)
 	connectionExtraData.Hashkey = hashKey
 
+	// When every pattern produced an empty key, the configured terminal action is applied
+	var forcedBackend string
+	if len(hashKey) == 0 {
+		onEmpty := route.HashKey.OnEmpty()
+
+		switch onEmpty.Action {
+		case HashKeyOnEmptyActionRandom:
+			hashKey = generateRandToken()
+
+		// The fixed backend is walked as a key, so its fallbacks are always the same
+		case HashKeyOnEmptyActionFixed:
+			hashKey = onEmpty.Backend
+			forcedBackend = onEmpty.Backend
+
+		default:
+			p.Logger.Error("error calculating hash_key: can not be empty")
+
+			httpRequestsTotalMetricLabels["delivered_status_code"] = strconv.Itoa(onEmpty.StatusCode)
+			httpRequestsTotalMetricLabels["error"] = "hash_key_calculation_failed"
+
+			writeDirectResponse(w, onEmpty.StatusCode, http.StatusText(onEmpty.StatusCode))
+			return
+		}
+	}
+
 	// get server
-	dueBackend := p.Hashring.GetServer(hashKey)
-	hashringServerPool := p.Hashring.GetServerList()
-	dueBackendPoolIndex := slices.Index(hashringServerPool, dueBackend)
+	// All the servers of this request are resolved against the same snapshot of the hashring,
+	// so membership changes happening meanwhile are not partially seen
+	hashringSnapshot := route.Hashring.Snapshot()
+	hashringServerPool := hashringSnapshot.GetServerList()
+
+	var dueBackend string
+	if forcedBackend != "" {
+		dueBackend = forcedBackend
+	} else if route.Config.Options.BoundedLoadsEpsilon > 0 {
+		dueBackend, connectionExtraData.Overflowed = hashringSnapshot.GetBoundedServer(hashKey, func(server string) bool {
+			return route.Load.IsOverloaded(server, hashringSnapshot.GetServerWeight(server), hashringSnapshot.GetTotalWeight(),
+				route.Config.Options.BoundedLoadsEpsilon)
+		})
+
+		if connectionExtraData.Overflowed {
+			p.Meter.BoundedLoadOverflowsTotal.With(map[string]string{
+				"proxy_name": p.SelfConfig.Name,
+				"route":      route.Config.Name,
+				"backend":    hashringSnapshot.GetServer(hashKey),
+			}).Add(1)
+		}
+	} else {
+		dueBackend = hashringSnapshot.GetServer(hashKey)
+	}
+
+	// Only the backends needed for the attempts are resolved, as most of the requests need just one.
+	// Ejected backends and those whose circuit is open are skipped, unless all of them are
+	maxAttempts := route.RetryPolicy.getMaxAttempts(r, len(hashringServerPool))
+	backendCandidates := getBackendCandidates(hashringSnapshot, hashKey, dueBackend, maxAttempts, func(server string) bool {
+		return !route.Outliers.IsEjected(server) && route.Breakers.IsAvailable(server)
+	})
 
 	var resp *http.Response
 	requestBodyContent := &bytes.Buffer{}
-	for i := 0; i < len(hashringServerPool); i++ {
 
-		// When the loop is in last item, start from the beginning
-		indexToTry := (dueBackendPoolIndex + i) % len(hashringServerPool)
+	// Headers are the same for all the attempts
+	backendRequestHeader := p.getBackendRequestHeader(r, route)
+
+	// The body is buffered while it's sent, so the following attempts can send it again.
+	// It is passed straight through when the request can not be retried
+	var retryBody *RetryBodyT
+	if maxAttempts > 1 && len(backendCandidates) > 1 {
+		retryBody = NewRetryBody(r.Body, route.Config.Options.RetryBodyBufferMemoryBytes,
+			route.Config.Options.RetryBodyBufferMaxBytes)
+		defer retryBody.Close()
+	}
+	defer func() {
+		if connectionExtraData.Attempts > 0 {
+			p.Meter.RequestAttempts.With(map[string]string{
+				"proxy_name": p.SelfConfig.Name,
+				"route":      route.Config.Name,
+			}).Observe(float64(connectionExtraData.Attempts))
+		}
+	}()
+
+	attempt := 0
+	for candidateIndex, currentSelectedBackend := range backendCandidates {
+		if attempt == maxAttempts {
+			break
+		}
 
-		currentSelectedBackend := hashringServerPool[indexToTry]
+		// Backends whose circuit is open are skipped, without counting as an attempt
+		if !route.Breakers.Allow(currentSelectedBackend) {
+			if lastErr == nil {
+				lastErr = errCircuitOpen
+			}
+			continue
+		}
 
-		url := fmt.Sprintf("http://%s%s", currentSelectedBackend, r.URL.Path+"?"+r.URL.RawQuery)
+		// Wait before retrying, so the backends are not flooded by the retries
+		if attempt > 0 {
+			if err = route.RetryPolicy.backoff(r.Context(), attempt); err != nil {
+				route.Breakers.Cancel(currentSelectedBackend)
+				lastErr = err
+				break
+			}
+		}
+		connectionExtraData.Attempts = attempt + 1
+
+		// Backends are identified by name in the hashring, but dialed by their current address
+		currentSelectedBackendAddress := hashringSnapshot.GetServerAddress(currentSelectedBackend)
+
+		// The path is sent exactly as it was received, and the query only when present
+		backendUrl := &url.URL{
+			Scheme:   getBackendScheme(route.Config.Options),
+			Host:     currentSelectedBackendAddress,
+			Path:     r.URL.Path,
+			RawPath:  r.URL.RawPath,
+			RawQuery: r.URL.RawQuery,
+		}
 
 		// The following is a trick to read the request body content
 		// using streaming techniques instead of wasting memory
 		// this way we can copy it keeping the memory consumption plain
 		// teeReader = (r.Body -> pipeWriter -> pipeReader -> sink)
 		pipeReader, pipeWriter := io.Pipe()
-		teeReader := io.TeeReader(r.Body, pipeWriter)
+		var requestBody io.Reader = r.Body
+		if retryBody != nil {
+			requestBody = retryBody.NewReader()
+		}
+		teeReader := io.TeeReader(requestBody, pipeWriter)
 
 		// Following goroutine is just for consuming from the pipe,
 		// storing or discarding the content, just because pipes are blocking
@@ -220,40 +401,128 @@ func (p *ProxyT) HTTPHandleFunc(w http.ResponseWriter, r *http.Request) {
 			io.Copy(io.Discard, pipeReader)
 		}()
 
+		// The trace reports the state of the backend connection used by this attempt.
+		// The attempt is cancelled when the per-try timeout is reached before receiving the response headers
+		requestContext := httptrace.WithClientTrace(r.Context(), getConnectionTrace())
+		attemptContext, cancelAttempt := context.WithCancelCause(requestContext)
+
 		//req, err := http.NewRequest(r.Method, url, r.Body)
-		req, err := http.NewRequest(r.Method, url, teeReader)
+		req, err := http.NewRequestWithContext(attemptContext, r.Method, backendUrl.String(), teeReader)
 		if err != nil {
 			p.Logger.Errorf("error creating request object: %s", err.Error())
+			pipeWriter.Close()
+			wg.Wait()
+			cancelAttempt(nil)
+			route.Breakers.Cancel(currentSelectedBackend)
+			lastErr = err
 			break
 		}
-		req.Header = r.Header
+		req.Header = backendRequestHeader
+		req.Host = getBackendRequestHost(r, route)
+
+		// Request trailers are filled by the server once the body is read, so the same map is forwarded
+		req.Trailer = r.Trailer
+
+		// Requests without body are sent without it, instead of an empty chunked one
+		req.ContentLength = r.ContentLength
+		if r.ContentLength == 0 {
+			req.Body = http.NoBody
+		}
 
 		// BackendCient represents the HTTP client to be used across concurrent requests
-		backendCient := p.getConfiguredHttpClient()
+		backendCient := route.Client
+
+		// The request is in-flight for the backend until the response is completely delivered
+		route.Load.Acquire(currentSelectedBackend)
 
 		//
+		stopPerTryTimer := route.RetryPolicy.startPerTryTimer(cancelAttempt)
+		attemptStart := time.Now()
 		resp, err = backendCient.Do(req)
+		attemptLatency := time.Since(attemptStart)
+		stopPerTryTimer()
 
 		// After .Do call finish, force closing body-stalker goroutine
 		// and wait before using the result
 		pipeWriter.Close()
 		wg.Wait()
 
-		if err == nil {
-			connectionExtraData.Backend = hashringServerPool[indexToTry]
-			lastErr = nil
+		// Requests cancelled by the client say nothing about the backend
+		if r.Context().Err() != nil {
+			route.Breakers.Cancel(currentSelectedBackend)
+		} else {
+			route.Breakers.Report(currentSelectedBackend,
+				err != nil || resp.StatusCode >= http.StatusInternalServerError, attemptLatency)
+
+			ejection := route.Outliers.ReportResult(currentSelectedBackend, len(hashringServerPool), resp, err)
+			if ejection > 0 {
+				p.Logger.Errorf("server '%s' (%s) ejected for %s after failing consecutive requests",
+					currentSelectedBackend, currentSelectedBackendAddress, ejection)
+				p.Meter.OutlierEjectionsTotal.With(map[string]string{
+					"proxy_name": p.SelfConfig.Name,
+					"route":      route.Config.Name,
+					"backend":    currentSelectedBackend,
+				}).Add(1)
+			}
+		}
+
+		// Decide whether the result of this attempt is retried on the next backend.
+		// It is only retried when a following backend can be tried, so the response is not lost
+		retryReason := ""
+		if attempt+1 < maxAttempts &&
+			slices.ContainsFunc(backendCandidates[candidateIndex+1:], route.Breakers.IsAvailable) {
+			retryReason = route.RetryPolicy.getRetryReason(attemptContext, resp, err)
+		}
+
+		if retryReason != "" && (retryBody == nil || !retryBody.IsReplayable()) {
+			// The body already sent can not be sent again when it was too big to be buffered
+			p.Logger.Infof("request body exceeds 'options.retry_body_buffer_max_bytes', skip trying another backend.")
+			retryReason = ""
+		}
+
+		if retryReason != "" && !isIdempotentRequest(r) && !(err != nil && isConnectError(err)) {
+			// Non-idempotent requests could have been processed by the failed backend,
+			// so they are only retried when they were never sent, if enabled
+			p.Logger.Infof("request method '%s' is not idempotent, skip trying another backend.", r.Method)
+			retryReason = ""
+		}
+
+		// The result of the attempt is delivered when it is not retried
+		if retryReason == "" {
+			if err == nil {
+				connectionExtraData.Backend = currentSelectedBackendAddress
+				connectionExtraData.CircuitBreaker = route.Breakers.State(currentSelectedBackend)
+				lastErr = nil
+				defer route.Load.Release(currentSelectedBackend)
+				defer cancelAttempt(nil)
+				defer resp.Body.Close()
+				break
+			}
+
+			route.Load.Release(currentSelectedBackend)
+			cancelAttempt(nil)
+			lastErr = err
+
+			p.Logger.Debugf("failed connecting to server '%s' (%s): %s", currentSelectedBackend,
+				currentSelectedBackendAddress, err.Error())
 			break
 		}
+
+		// The response of the failed attempt is discarded
+		if resp != nil {
+			err = fmt.Errorf("backend responded with status '%s'", resp.Status)
+			resp.Body.Close()
+			resp = nil
+		}
+		route.Load.Release(currentSelectedBackend)
+		cancelAttempt(nil)
 		lastErr = err
 
 		// TODO: Discuss this message usefulness with more people
-		p.Logger.Debugf("failed connecting to server '%s': %s", hashringServerPool[indexToTry], err.Error())
+		p.Logger.Debugf("retrying request on another backend after '%s' in server '%s' (%s): %s", retryReason,
+			currentSelectedBackend, currentSelectedBackendAddress, err.Error())
 
-		// There is an error but user does not want to try another backend
-		if !p.SelfConfig.Options.TryAnotherBackendOnFailure {
-			p.Logger.Infof("'options.try_another_backend_on_failure' is disabled, skip trying another backend.")
-			break
-		}
+		attempt++
 	}
 
 	if lastErr != nil {
@@ -286,15 +555,18 @@ func (p *ProxyT) HTTPHandleFunc(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Clone the headers
-	for k, v := range resp.Header {
-		for _, headV := range v {
-			w.Header().Set(k, headV)
-		}
-	}
+	p.copyBackendResponseHeader(w, resp, route)
+
+	announcedTrailers := announceBackendResponseTrailers(w, resp)
 
 	// set status code of the response
 	w.WriteHeader(resp.StatusCode)
 
+	// Announced trailers force sending the body in chunks
+	if flusher, ok := w.(http.Flusher); ok && len(announcedTrailers) > 0 {
+		flusher.Flush()
+	}
+
 	// Copy the data without trully reading it
 	httpRequestsTotalMetricLabels["delivered_status_code"] = strconv.Itoa(resp.StatusCode)
 	httpRequestsTotalMetricLabels["error"] = "none"
@@ -306,6 +578,9 @@ func (p *ProxyT) HTTPHandleFunc(w http.ResponseWriter, r *http.Request) {
 		httpRequestsTotalMetricLabels["error"] = "body_copy_failed"
 	}
 
+	// Trailers are available once the body is completely read
+	copyBackendResponseTrailers(w, resp, announcedTrailers)
+
 	//
 	if p.CommonConfig.Logs.ShowAccessLogs {
 		logFields := GetResponseLogFields(resp, connectionExtraData, p.CommonConfig.Logs.AccessLogsFields)
@@ -324,6 +599,15 @@ func (p *ProxyT) RunHttp() (err error) {
 		p.SelfConfig.Listener.Address+":"+strconv.Itoa(p.SelfConfig.Listener.Port),
 		http.HandlerFunc(p.HTTPHandleFunc))
 
+	// Certificates are provided by the TLS configuration, so they are not passed here.
+	// HTTP/2 is negotiated by default over TLS, so it is disabled to serve HTTP/1.1 only
+	if p.TlsConfig != nil {
+		httpServer.TLSConfig = p.TlsConfig
+		httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		err = httpServer.ListenAndServeTLS("", "")
+		return err
+	}
+
 	err = httpServer.ListenAndServe()
 
 	return err
@@ -3,9 +3,109 @@
 
 package proxy
 
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+
+	"hashrouter/api"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+
+	// Protocols available to talk to the backends
+	BackendProtocolHttp1 = "http1"
+	BackendProtocolH2    = "h2"
+	BackendProtocolH2c   = "h2c"
+)
+
+// getBackendScheme returns the URL scheme used to reach the backends according to the given options
+func getBackendScheme(options api.OptionsT) string {
+	if options.HttpBackendProtocol == BackendProtocolH2 {
+		return "https"
+	}
+	return "http"
+}
+
+// getHttp2Transport returns a transport talking HTTP/2 to the backends:
+// over TLS for 'h2', and in cleartext with prior knowledge for 'h2c'.
+// Connections are opened by the given dial function
+func getHttp2Transport(options api.OptionsT,
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)) *http2.Transport {
+
+	if options.HttpBackendProtocol == BackendProtocolH2c {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialContext(ctx, network, addr)
+			},
+		}
+	}
+
+	return &http2.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: isEnabled(options.HttpBackendTlsInsecureSkipVerify),
+		},
+		DialTLSContext: func(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error) {
+			conn, err := dialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			// The handshake is performed over the connection already dialed,
+			// so the TLS layer does not hide it from the connection tracking
+			tlsConfig := config.Clone()
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err = tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+	}
+}
+
+// RunHttp2 launches the listener accepting HTTP/2 requests, along with HTTP/1 ones.
+// HTTP/2 is negotiated by ALPN when TLS is configured (h2). Otherwise, it's accepted in cleartext (h2c)
+// both with prior knowledge and by upgrading HTTP/1 connections
 func (p *ProxyT) RunHttp2() (err error) {
 
-	p.Logger.Infof("not implemented yet")
-	return err
+	p.Status.RWMutex.Lock()
+	p.Status.IsHealthy = true
+	p.Status.RWMutex.Unlock()
+
+	http2Server := &http2.Server{}
 
+	//
+	if p.TlsConfig != nil {
+		httpServer := p.getConfiguredHttpServer(
+			p.SelfConfig.Listener.Address+":"+strconv.Itoa(p.SelfConfig.Listener.Port),
+			http.HandlerFunc(p.HTTPHandleFunc))
+		httpServer.TLSConfig = p.TlsConfig.Clone()
+
+		err = http2.ConfigureServer(httpServer, http2Server)
+		if err != nil {
+			return err
+		}
+
+		err = httpServer.ListenAndServeTLS("", "")
+		return err
+	}
+
+	//
+	httpServer := p.getConfiguredHttpServer(
+		p.SelfConfig.Listener.Address+":"+strconv.Itoa(p.SelfConfig.Listener.Port),
+		h2c.NewHandler(http.HandlerFunc(p.HTTPHandleFunc), http2Server))
+
+	err = httpServer.ListenAndServe()
+	return err
 }
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"math"
+	"sync"
+)
+
+// LoadTrackerT keeps the amount of in-flight requests for each backend.
+// It is used to bound the load of the backends when consistent hashing with bounded loads is enabled
+type LoadTrackerT struct {
+	sync.Mutex
+
+	inFlight map[string]int64
+	total    int64
+}
+
+// NewLoadTracker returns a new LoadTrackerT instance
+func NewLoadTracker() *LoadTrackerT {
+	return &LoadTrackerT{
+		inFlight: make(map[string]int64),
+	}
+}
+
+// Acquire registers a new in-flight request for the given backend
+func (l *LoadTrackerT) Acquire(backend string) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.inFlight[backend]++
+	l.total++
+}
+
+// Release unregisters an in-flight request for the given backend
+func (l *LoadTrackerT) Release(backend string) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.inFlight[backend]--
+	l.total--
+
+	if l.inFlight[backend] <= 0 {
+		delete(l.inFlight, backend)
+	}
+}
+
+// IsOverloaded checks whether the given backend can not accept one more request
+// without exceeding its share of '(1+epsilon) × total load'. The share of a backend is
+// its weight out of the total weight of the backends, so heavier backends accept more load
+func (l *LoadTrackerT) IsOverloaded(backend string, weight int, totalWeight int, epsilon float64) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if totalWeight == 0 {
+		return false
+	}
+
+	// The request being placed is counted as part of the load
+	capacity := math.Ceil((1 + epsilon) * float64(l.total+1) * float64(weight) / float64(totalWeight))
+	return float64(l.inFlight[backend]+1) > capacity
+}
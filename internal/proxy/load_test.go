@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"testing"
+
+	"hashrouter/internal/hashring"
+)
+
+// TestBoundedLoads checks that keys are moved to the next servers in ring order
+// while the previous ones are past their load bound
+func TestBoundedLoads(t *testing.T) {
+	const (
+		key     = "/assets/app.js"
+		epsilon = 0.25
+	)
+
+	tests := []struct {
+		name string
+
+		// Weight of the server the key belongs to. The rest have weight 1
+		primaryWeight int
+
+		// In-flight requests of the servers, in the ring order of the key
+		loads []int
+
+		// Position in the ring order of the expected server
+		expected   int
+		overflowed bool
+	}{
+		{name: "no load", primaryWeight: 1, loads: []int{0, 0, 0, 0}, expected: 0},
+		{name: "load under the bound", primaryWeight: 1, loads: []int{1, 1, 1, 1}, expected: 0},
+		{name: "server past the bound", primaryWeight: 1, loads: []int{1, 0, 0, 0}, expected: 1, overflowed: true},
+		{name: "next server past the bound too", primaryWeight: 1, loads: []int{1, 1, 0, 0}, expected: 2, overflowed: true},
+		{name: "heavier server accepts more load", primaryWeight: 3, loads: []int{1, 0, 0, 0}, expected: 0},
+		{name: "heavier server past the bound", primaryWeight: 3, loads: []int{3, 0, 0, 0}, expected: 1, overflowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring, err := hashring.NewHashRing(hashring.AlgorithmVnode, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			membership := []hashring.Member{
+				{Name: "backend-0", Weight: 1},
+				{Name: "backend-1", Weight: 1},
+				{Name: "backend-2", Weight: 1},
+				{Name: "backend-3", Weight: 1},
+			}
+			ring.ApplyMembership(membership, nil)
+
+			// The server the key belongs to gets its weight. It keeps the key, as heavier servers own more of the ring
+			primary := ring.GetServer(key)
+			ring.ApplyMembership([]hashring.Member{{Name: primary, Weight: test.primaryWeight}}, nil)
+			snapshot := ring.Snapshot()
+
+			order := snapshot.GetServers(key, len(membership))
+			if order[0] != primary {
+				t.Fatalf("server of the key changed from '%s' to '%s'", primary, order[0])
+			}
+
+			load := NewLoadTracker()
+			for position, inFlight := range test.loads {
+				for i := 0; i < inFlight; i++ {
+					load.Acquire(order[position])
+				}
+			}
+
+			server, overflowed := snapshot.GetBoundedServer(key, func(server string) bool {
+				return load.IsOverloaded(server, snapshot.GetServerWeight(server), snapshot.GetTotalWeight(), epsilon)
+			})
+
+			if server != order[test.expected] || overflowed != test.overflowed {
+				t.Fatalf("got server '%s' (overflowed: %t), want '%s' (overflowed: %t) in order %v",
+					server, overflowed, order[test.expected], test.overflowed, order)
+			}
+		})
+	}
+}
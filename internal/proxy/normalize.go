@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"hashrouter/api"
+)
+
+// HashKeyNormalizerT normalizes the hash keys, so equivalent requests get the same key.
+// Keys are considered as URLs: the path part is what comes before the first '?',
+// and the query part is what comes after it
+type HashKeyNormalizerT struct {
+	config api.HashKeyNormalizeT
+}
+
+// NewHashKeyNormalizer returns a HashKeyNormalizerT for the given configuration
+func NewHashKeyNormalizer(config api.HashKeyNormalizeT) (*HashKeyNormalizerT, error) {
+
+	if len(config.QueryAllowlist) > 0 && len(config.QueryDenylist) > 0 {
+		return nil, fmt.Errorf("hash_key normalize query_allowlist and query_denylist are mutually exclusive")
+	}
+
+	for _, pattern := range append(slices.Clone(config.QueryAllowlist), config.QueryDenylist...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("error parsing hash_key normalize query pattern '%s': %s", pattern, err.Error())
+		}
+	}
+
+	return &HashKeyNormalizerT{
+		config: config,
+	}, nil
+}
+
+// Normalize returns the given key with the configured normalizations applied
+func (n *HashKeyNormalizerT) Normalize(key string) string {
+
+	if n.config.Lowercase {
+		key = strings.ToLower(key)
+	}
+
+	pathPart, queryPart, hasQuery := strings.Cut(key, "?")
+
+	//
+	if n.config.PercentDecode {
+		if decoded, err := url.PathUnescape(pathPart); err == nil {
+			pathPart = decoded
+		}
+	}
+
+	if n.config.CollapseSlashes {
+		for strings.Contains(pathPart, "//") {
+			pathPart = strings.ReplaceAll(pathPart, "//", "/")
+		}
+	}
+
+	if n.config.StripTrailingSlash && len(pathPart) > 1 {
+		pathPart = strings.TrimSuffix(pathPart, "/")
+	}
+
+	if !hasQuery {
+		return pathPart
+	}
+
+	//
+	queryPart = n.normalizeQuery(queryPart)
+	if queryPart == "" {
+		return pathPart
+	}
+
+	return pathPart + "?" + queryPart
+}
+
+// normalizeQuery returns the given query with its parameters filtered, decoded and sorted as configured.
+// Parameters keep their original encoding unless percent decoding is enabled
+func (n *HashKeyNormalizerT) normalizeQuery(query string) string {
+
+	params := strings.Split(query, "&")
+	params = slices.DeleteFunc(params, func(param string) bool {
+		if param == "" {
+			return true
+		}
+
+		name, _, _ := strings.Cut(param, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+
+		if len(n.config.QueryAllowlist) > 0 {
+			return !matchesAnyPattern(n.config.QueryAllowlist, name)
+		}
+		return matchesAnyPattern(n.config.QueryDenylist, name)
+	})
+
+	if n.config.PercentDecode {
+		for i, param := range params {
+			if decoded, err := url.QueryUnescape(param); err == nil {
+				params[i] = decoded
+			}
+		}
+	}
+
+	// Stable sorting keeps the order of the repeated parameters, as it is meaningful
+	if n.config.SortQuery {
+		slices.SortStableFunc(params, func(a, b string) int {
+			nameA, _, _ := strings.Cut(a, "=")
+			nameB, _, _ := strings.Cut(b, "=")
+			return strings.Compare(nameA, nameB)
+		})
+	}
+
+	return strings.Join(params, "&")
+}
+
+// matchesAnyPattern returns whether the given name matches any of the glob patterns
+func matchesAnyPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"hashrouter/api"
+)
+
+const (
+
+	// Consecutive failed requests needed to eject a backend
+	// (default: 5)
+	defaultOutlierConsecutiveErrors = 5
+
+	// Time a backend is ejected the first time. It grows with each consecutive ejection
+	// (default: 30s)
+	defaultOutlierBaseEjectionTime = 30 * time.Second
+
+	// Maximum time a backend is ejected
+	// (default: 5m)
+	defaultOutlierMaxEjectionTime = 5 * time.Minute
+
+	// Maximum percentage of the backends ejected at the same time. One backend can always be ejected
+	// (default: 10)
+	defaultOutlierMaxEjectionPercent = 10
+)
+
+// OutlierDetectorT ejects from the selection the backends failing consecutive requests.
+// Ejected backends are skipped when selecting a backend, without changing the hashring
+type OutlierDetectorT struct {
+	sync.Mutex
+
+	//
+	enabled            bool
+	consecutiveErrors  int
+	baseEjectionTime   time.Duration
+	maxEjectionTime    time.Duration
+	maxEjectionPercent int
+
+	//
+	backends map[string]*outlierStateT
+}
+
+// outlierStateT holds the results of the requests sent to a backend
+type outlierStateT struct {
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// NewOutlierDetector returns an OutlierDetectorT for the given configuration.
+// Detection is disabled when nothing is configured
+func NewOutlierDetector(config api.OutlierDetectionT) (detector *OutlierDetectorT, err error) {
+
+	detector = &OutlierDetectorT{
+		enabled:            !reflect.ValueOf(config).IsZero(),
+		consecutiveErrors:  defaultOutlierConsecutiveErrors,
+		baseEjectionTime:   defaultOutlierBaseEjectionTime,
+		maxEjectionTime:    defaultOutlierMaxEjectionTime,
+		maxEjectionPercent: defaultOutlierMaxEjectionPercent,
+		backends:           make(map[string]*outlierStateT),
+	}
+
+	if config.ConsecutiveErrors < 0 || config.BaseEjectionTime < 0 || config.MaxEjectionTime < 0 {
+		return nil, fmt.Errorf("values can not be negative")
+	}
+
+	if config.MaxEjectionPercent < 0 || config.MaxEjectionPercent > 100 {
+		return nil, fmt.Errorf("max ejection percent must be between 0 and 100")
+	}
+
+	if config.ConsecutiveErrors > 0 {
+		detector.consecutiveErrors = config.ConsecutiveErrors
+	}
+
+	if config.BaseEjectionTime > 0 {
+		detector.baseEjectionTime = config.BaseEjectionTime
+	}
+
+	if config.MaxEjectionTime > 0 {
+		detector.maxEjectionTime = config.MaxEjectionTime
+	}
+
+	if config.MaxEjectionPercent > 0 {
+		detector.maxEjectionPercent = config.MaxEjectionPercent
+	}
+
+	return detector, nil
+}
+
+// IsEjected returns whether the given backend is currently ejected from the selection
+func (d *OutlierDetectorT) IsEjected(backend string) bool {
+	if !d.enabled {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	state, found := d.backends[backend]
+	return found && time.Now().Before(state.ejectedUntil)
+}
+
+// ReportResult registers the result of a request sent to the given backend, out of 'numBackends'.
+// Connection errors and 5xx responses are failures. It returns the ejection time
+// when the backend is ejected because of this result
+func (d *OutlierDetectorT) ReportResult(backend string, numBackends int, resp *http.Response, err error) (ejection time.Duration) {
+	if !d.enabled {
+		return 0
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	state, found := d.backends[backend]
+	if !found {
+		state = &outlierStateT{}
+		d.backends[backend] = state
+	}
+
+	if err == nil && resp.StatusCode < http.StatusInternalServerError {
+		state.failures = 0
+		return 0
+	}
+
+	now := time.Now()
+	state.failures++
+	if state.failures < d.consecutiveErrors || now.Before(state.ejectedUntil) {
+		return 0
+	}
+
+	// The percentage of ejected backends is guarded, so the rest are not overloaded
+	ejected := 0
+	for _, otherState := range d.backends {
+		if now.Before(otherState.ejectedUntil) {
+			ejected++
+		}
+	}
+
+	if ejected > 0 && (ejected+1)*100 > d.maxEjectionPercent*numBackends {
+		return 0
+	}
+
+	// Ejections are forgotten one by one, for each base ejection time elapsed without being ejected
+	if !state.ejectedUntil.IsZero() {
+		state.ejections = max(state.ejections-int(now.Sub(state.ejectedUntil)/d.baseEjectionTime), 0)
+	}
+	state.ejections++
+
+	ejection = min(d.baseEjectionTime*time.Duration(state.ejections), d.maxEjectionTime)
+	state.ejectedUntil = now.Add(ejection)
+	state.failures = 0
+
+	return ejection
+}
@@ -4,11 +4,15 @@
 package proxy
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
 	"sync"
 	"time"
 
 	"hashrouter/api"
-	"hashrouter/internal/hashring"
 	"hashrouter/internal/metrics"
 
 	"go.uber.org/zap"
@@ -28,9 +32,13 @@ type ProxyT struct {
 	CommonConfig api.CommonT
 	SelfConfig   api.ProxyT
 
-	//
-	Hashring *hashring.HashRing
-	Status   *ProxyStatusT
+	// Routes are evaluated in order, and the first one matching the request serves it
+	Routes []*RouteT
+	Status *ProxyStatusT
+
+	// TLS configuration of the listener. Nil when it serves plain HTTP
+	TlsConfig       *tls.Config
+	TlsCertificates *CertificateStoreT
 
 	//
 	Logger *zap.SugaredLogger
@@ -45,8 +53,8 @@ func NewProxy(commonConfig api.CommonT, selfConfig api.ProxyT, log *zap.SugaredL
 		SelfConfig:   selfConfig,
 
 		//
-		Hashring: nil,
-		Status:   &ProxyStatusT{},
+		Routes: nil,
+		Status: &ProxyStatusT{},
 
 		// TODO: These objects can be joined into a single 'InstrumentationT' struct
 		Logger: log,
@@ -56,6 +64,61 @@ func NewProxy(commonConfig api.CommonT, selfConfig api.ProxyT, log *zap.SugaredL
 	return proxy
 }
 
+// BuildRoutes creates the routes of the proxy according to its configuration.
+// Backends defined at proxy level build a last route, matching any request not matched before
+func (p *ProxyT) BuildRoutes() (err error) {
+
+	routeConfigs := slices.Clone(p.SelfConfig.Routes)
+	if !reflect.ValueOf(p.SelfConfig.Backends).IsZero() {
+		routeConfigs = append(routeConfigs, api.RouteT{
+			Name:     DefaultRouteName,
+			Backends: p.SelfConfig.Backends,
+			HashKey:  p.SelfConfig.HashKey,
+		})
+	}
+
+	if len(routeConfigs) == 0 {
+		return fmt.Errorf("backends not defined")
+	}
+
+	routes := make([]*RouteT, 0, len(routeConfigs))
+	for _, routeConfig := range routeConfigs {
+
+		if routeConfig.Name == "" {
+			return fmt.Errorf("route name can not be empty")
+		}
+
+		if slices.ContainsFunc(routes, func(route *RouteT) bool { return route.Config.Name == routeConfig.Name }) {
+			return fmt.Errorf("route name '%s' is duplicated", routeConfig.Name)
+		}
+
+		route, err := NewRoute(routeConfig, p.SelfConfig, p.Logger)
+		if err != nil {
+			return fmt.Errorf("error creating route '%s': %s", routeConfig.Name, err.Error())
+		}
+		route.Connections = NewConnectionTracker(p.SelfConfig.Name, route.Config.Name, route.Hashring, p.Meter)
+		route.Client = p.getConfiguredHttpClient(route)
+		route.HealthChecker = NewHealthChecker(p.SelfConfig.Name, route.Config.Name,
+			route.Config.Backends.HealthCheckConcurrency, route.SyncTime, route.Logger, p.Meter)
+		route.Breakers.OnChange = p.getCircuitChangeHandler(route)
+
+		routes = append(routes, route)
+	}
+
+	p.Routes = routes
+	return nil
+}
+
+// GetRoute returns the first route matching the given request, or nil when none of them matches
+func (p *ProxyT) GetRoute(req *http.Request) *RouteT {
+	for _, route := range p.Routes {
+		if route.Matches(req) {
+			return route
+		}
+	}
+	return nil
+}
+
 // Run launches the proxy and keeps it running.
 // Intended to be run as a goroutine
 func (p *ProxyT) Run(waitGroup *sync.WaitGroup) {
@@ -0,0 +1,274 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+
+	"hashrouter/api"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+
+	// Conditions available to retry a request on another backend
+	RetryOnConnectFailure = "connect-failure"
+	RetryOnReset          = "reset"
+	RetryOnTimeout        = "timeout"
+	RetryOn5xx            = "5xx"
+	RetryOnStatusCodes    = "status-codes"
+	RetryOnHeaders        = "headers"
+
+	// Condition used by 'try_another_backend_on_failure', matching any error talking to the backend
+	retryOnError = "error"
+
+	// Base interval of the exponential backoff between attempts.
+	// (default: 25ms)
+	defaultRetryBackoffBaseInterval = 25 * time.Millisecond
+
+	// Maximum interval of the exponential backoff between attempts.
+	// (default: 250ms)
+	defaultRetryBackoffMaxInterval = 250 * time.Millisecond
+)
+
+var (
+
+	// Methods whose requests can be sent several times with the same effect (RFC 9110)
+	idempotentMethods = []string{
+		http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete,
+	}
+
+	//
+	errPerTryTimeout = errors.New("per-try timeout reached")
+)
+
+// RetryPolicyT decides whether a failed attempt is retried on another backend, and how
+type RetryPolicyT struct {
+	retryOn     []string
+	statusCodes []int
+	headers     []string
+
+	// Non-idempotent requests are only retried on connect errors, when enabled
+	retryNonIdempotent bool
+
+	//
+	maxAttempts         int
+	perTryTimeout       time.Duration
+	backoffBaseInterval time.Duration
+	backoffMaxInterval  time.Duration
+}
+
+// NewRetryPolicy returns the RetryPolicyT defined by the given options.
+// When 'retry_policy' is not set, requests are retried on any error if 'try_another_backend_on_failure' is enabled
+func NewRetryPolicy(options api.OptionsT) (policy *RetryPolicyT, err error) {
+
+	config := options.RetryPolicy
+
+	//
+	if len(config.RetryOn) == 0 {
+		policy = &RetryPolicyT{
+			retryNonIdempotent: isEnabled(options.RetryNonIdempotentOnConnectErrors),
+			maxAttempts:        config.MaxAttempts,
+			perTryTimeout:      config.PerTryTimeout,
+		}
+
+		if isEnabled(options.TryAnotherBackendOnFailure) {
+			policy.retryOn = []string{retryOnError}
+		}
+		return policy, nil
+	}
+
+	policy = &RetryPolicyT{
+		retryOn:             config.RetryOn,
+		statusCodes:         config.StatusCodes,
+		headers:             config.Headers,
+		retryNonIdempotent:  isEnabled(options.RetryNonIdempotentOnConnectErrors),
+		maxAttempts:         config.MaxAttempts,
+		perTryTimeout:       config.PerTryTimeout,
+		backoffBaseInterval: defaultRetryBackoffBaseInterval,
+		backoffMaxInterval:  defaultRetryBackoffMaxInterval,
+	}
+
+	for _, condition := range config.RetryOn {
+		switch condition {
+		case RetryOnConnectFailure, RetryOnReset, RetryOnTimeout, RetryOn5xx:
+		case RetryOnStatusCodes:
+			if len(config.StatusCodes) == 0 {
+				return nil, fmt.Errorf("retry_on condition '%s' requires status_codes", condition)
+			}
+		case RetryOnHeaders:
+			if len(config.Headers) == 0 {
+				return nil, fmt.Errorf("retry_on condition '%s' requires headers", condition)
+			}
+		default:
+			return nil, fmt.Errorf("unknown retry_on condition '%s'", condition)
+		}
+	}
+
+	if config.MaxAttempts < 0 {
+		return nil, fmt.Errorf("max_attempts can not be negative")
+	}
+
+	if config.BackoffBaseInterval > 0 {
+		policy.backoffBaseInterval = config.BackoffBaseInterval
+	}
+
+	if config.BackoffMaxInterval > 0 {
+		policy.backoffMaxInterval = config.BackoffMaxInterval
+	}
+
+	if policy.backoffMaxInterval < policy.backoffBaseInterval {
+		return nil, fmt.Errorf("backoff_max_interval can not be lower than backoff_base_interval")
+	}
+
+	return policy, nil
+}
+
+// CanRetry returns whether any condition retries the failed attempts
+func (p *RetryPolicyT) CanRetry() bool {
+	return len(p.retryOn) > 0
+}
+
+// CanRetryRequest returns whether the failed attempts of the given request can be retried at all
+func (p *RetryPolicyT) CanRetryRequest(req *http.Request) bool {
+	return p.CanRetry() && (p.retryNonIdempotent || isIdempotentRequest(req))
+}
+
+// getMaxAttempts returns the maximum attempts for the given request with the given amount of candidate backends.
+// Each attempt is sent to a different backend, and only one is sent when the request can not be retried
+func (p *RetryPolicyT) getMaxAttempts(req *http.Request, candidates int) int {
+	if !p.CanRetryRequest(req) {
+		return min(1, candidates)
+	}
+
+	if p.maxAttempts > 0 {
+		return min(p.maxAttempts, candidates)
+	}
+	return candidates
+}
+
+// getRetryReason returns the condition of the policy matched by the result of an attempt,
+// or an empty string when the attempt must not be retried.
+// The context is the one of the attempt, used to know whether it was cancelled by the per-try timeout
+func (p *RetryPolicyT) getRetryReason(ctx context.Context, resp *http.Response, err error) string {
+
+	// Requests cancelled by the client are never retried
+	if ctx.Err() != nil && !errors.Is(context.Cause(ctx), errPerTryTimeout) {
+		return ""
+	}
+
+	for _, condition := range p.retryOn {
+		matched := false
+
+		switch condition {
+		case retryOnError:
+			matched = err != nil
+		case RetryOnConnectFailure:
+			matched = err != nil && isConnectError(err)
+		case RetryOnTimeout:
+			matched = err != nil && !isConnectError(err) && isTimeoutError(ctx, err)
+		case RetryOnReset:
+			matched = err != nil && !isConnectError(err) && isResetError(err)
+		case RetryOn5xx:
+			matched = resp != nil && resp.StatusCode >= 500
+		case RetryOnStatusCodes:
+			matched = resp != nil && slices.Contains(p.statusCodes, resp.StatusCode)
+		case RetryOnHeaders:
+			matched = resp != nil && slices.ContainsFunc(p.headers, func(header string) bool {
+				return len(resp.Header.Values(header)) > 0
+			})
+		}
+
+		if matched {
+			return condition
+		}
+	}
+
+	return ""
+}
+
+// startPerTryTimer cancels the attempt when the per-try timeout is reached.
+// The returned function stops the timer, and must be called once the response headers are received
+func (p *RetryPolicyT) startPerTryTimer(cancel context.CancelCauseFunc) (stop func()) {
+	if p.perTryTimeout <= 0 {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(p.perTryTimeout, func() { cancel(errPerTryTimeout) })
+	return func() { timer.Stop() }
+}
+
+// backoff waits before the given retry (starting from 1) for a random interval up to
+// the exponential backoff, to avoid retrying in lockstep. It returns early when the context is done
+func (p *RetryPolicyT) backoff(ctx context.Context, retry int) error {
+	if p.backoffBaseInterval <= 0 {
+		return nil
+	}
+
+	interval := p.backoffMaxInterval
+	if retry < 32 {
+		interval = min(p.backoffBaseInterval<<(retry-1), p.backoffMaxInterval)
+	}
+
+	timer := time.NewTimer(rand.N(interval) + 1)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isIdempotentRequest returns whether the given request can be sent again to another backend.
+// As in the Go standard library, requests with an idempotency key are considered idempotent too
+func isIdempotentRequest(req *http.Request) bool {
+	if slices.Contains(idempotentMethods, req.Method) {
+		return true
+	}
+
+	_, hasIdempotencyKey := req.Header["Idempotency-Key"]
+	_, hasXIdempotencyKey := req.Header["X-Idempotency-Key"]
+	return hasIdempotencyKey || hasXIdempotencyKey
+}
+
+// isConnectError returns whether the given error happened connecting to the backend,
+// so the request was never sent to it
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isTimeoutError returns whether the given error was caused by a timeout,
+// including the per-try timeout of the given attempt context
+func isTimeoutError(ctx context.Context, err error) bool {
+	if errors.Is(context.Cause(ctx), errPerTryTimeout) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isResetError returns whether the given error was caused by the backend closing
+// the connection or the stream before sending the response
+func isResetError(err error) bool {
+	var streamErr http2.StreamError
+	var goAwayErr http2.GoAwayError
+
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &streamErr) || errors.As(err, &goAwayErr)
+}
@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"hashrouter/api"
+	"hashrouter/internal/hashring"
+	"hashrouter/internal/metrics"
+)
+
+var (
+	// Metrics are registered globally, so all the tests share them
+	testMeter = sync.OnceValue(func() *metrics.PoolT {
+		meter := &metrics.PoolT{}
+		meter.RegisterMetrics(nil)
+		return meter
+	})
+)
+
+// newTestProxy returns a proxy whose only route sends the requests to the given backends.
+// The configured backend is never used, as the hashring is filled directly and the synchronizer is not started
+func newTestProxy(t *testing.T, options api.OptionsT, backends ...*httptest.Server) *ProxyT {
+	t.Helper()
+
+	config := api.ProxyT{
+		Name:    "test",
+		Options: options,
+		HashKey: api.HashKeyT{Pattern: "${REQUEST:path}"},
+		Routes: []api.RouteT{{
+			Name:     "test",
+			Backends: api.BackendsT{Synchronization: "1s", Static: []api.BackendsStaticT{{Host: "placeholder:80"}}},
+		}},
+	}
+
+	proxy := NewProxy(api.CommonT{}, config, zap.NewNop().Sugar(), testMeter())
+	if err := proxy.BuildRoutes(); err != nil {
+		t.Fatal(err)
+	}
+
+	membership := []hashring.Member{}
+	for i, backend := range backends {
+		membership = append(membership, hashring.Member{
+			Name:    "backend-" + strconv.Itoa(i),
+			Address: strings.TrimPrefix(backend.URL, "http://"),
+		})
+	}
+	proxy.Routes[0].Hashring.ApplyMembership(membership, nil)
+
+	return proxy
+}
+
+// newTestBackends returns 'n' backends sharing the same handler.
+// The handler receives the order of the request among all the backends, starting from 0
+func newTestBackends(t *testing.T, n int, handler func(w http.ResponseWriter, r *http.Request, order int64)) []*httptest.Server {
+	t.Helper()
+
+	var requests atomic.Int64
+	backends := []*httptest.Server{}
+	for i := 0; i < n; i++ {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r, requests.Add(1)-1)
+		}))
+		t.Cleanup(backend.Close)
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
+// TestRetry checks the retries of the requests failing on the first backend
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		options api.OptionsT
+
+		// Handler of the first request, the following ones echo the body
+		firstHandler func(w http.ResponseWriter, r *http.Request)
+
+		expectedStatus   int
+		expectedRequests int64
+	}{
+		{
+			name:    "5xx retried with the same body",
+			method:  http.MethodPut,
+			options: api.OptionsT{RetryPolicy: api.RetryPolicyT{RetryOn: []string{RetryOn5xx}}},
+			firstHandler: func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 2,
+		},
+		{
+			name:    "non-idempotent request not retried",
+			method:  http.MethodPost,
+			options: api.OptionsT{RetryPolicy: api.RetryPolicyT{RetryOn: []string{RetryOn5xx}}},
+			firstHandler: func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedRequests: 1,
+		},
+		{
+			name:   "per-try timeout retried",
+			method: http.MethodGet,
+			options: api.OptionsT{RetryPolicy: api.RetryPolicyT{
+				RetryOn:       []string{RetryOnTimeout},
+				PerTryTimeout: 100 * time.Millisecond,
+			}},
+			firstHandler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int64
+			backends := newTestBackends(t, 3, func(w http.ResponseWriter, r *http.Request, order int64) {
+				requests.Add(1)
+				if order == 0 {
+					test.firstHandler(w, r)
+					return
+				}
+				io.Copy(w, r.Body)
+			})
+			proxy := newTestProxy(t, test.options, backends...)
+
+			body := bytes.Repeat([]byte("0123456789"), 100000)
+			if test.method == http.MethodGet {
+				body = nil
+			}
+
+			recorder := httptest.NewRecorder()
+			start := time.Now()
+			proxy.HTTPHandleFunc(recorder, httptest.NewRequest(test.method, "http://example.com/item", bytes.NewReader(body)))
+
+			if recorder.Code != test.expectedStatus {
+				t.Fatalf("got status %d, want %d", recorder.Code, test.expectedStatus)
+			}
+
+			if requests.Load() != test.expectedRequests {
+				t.Fatalf("backends got %d requests, want %d", requests.Load(), test.expectedRequests)
+			}
+
+			if test.expectedStatus == http.StatusOK && !bytes.Equal(recorder.Body.Bytes(), body) {
+				t.Fatalf("got a body of %d bytes, want the %d bytes sent", recorder.Body.Len(), len(body))
+			}
+
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("request took %s", elapsed)
+			}
+		})
+	}
+}
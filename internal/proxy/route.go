@@ -0,0 +1,387 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"hashrouter/api"
+	"hashrouter/internal/hashring"
+
+	"go.uber.org/zap"
+)
+
+const (
+
+	// Name of the route built from the backends defined at proxy level
+	DefaultRouteName = "default"
+)
+
+// RouteT represents a set of backends serving the requests matching some conditions.
+// Each route has its own hashring, kept up to date by its own synchronizer
+type RouteT struct {
+
+	// Config is the route configuration, with the options not set inherited from the proxy
+	Config   api.RouteT
+	SyncTime time.Duration
+
+	//
+	Hashring       *hashring.HashRing
+	HashKey        *HashKeyCalculatorT
+	TrustedProxies []*net.IPNet
+	Load           *LoadTrackerT
+	RetryPolicy    *RetryPolicyT
+	Outliers       *OutlierDetectorT
+	Breakers       *CircuitBreakerT
+	Connections    *ConnectionTrackerT
+
+	// Client shared by all the requests sent to the backends of the route
+	Client *http.Client
+
+	// HealthChecker keeps the health of the backends, read by the synchronizer
+	HealthChecker *HealthCheckerT
+
+	//
+	matcher *routeMatcherT
+
+	//
+	Logger *zap.SugaredLogger
+}
+
+// routeMatcherT is the compiled version of the route match conditions
+type routeMatcherT struct {
+	hosts      []string
+	pathPrefix string
+	pathRegex  *regexp.Regexp
+	methods    []string
+	headers    []routeHeaderMatcherT
+}
+
+type routeHeaderMatcherT struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+// NewRoute returns a RouteT ready to be synchronized for the given configuration.
+// Options not set in the route are inherited from the given proxy options,
+// and so is the hash key when it is not set at all
+func NewRoute(config api.RouteT, proxyConfig api.ProxyT, logger *zap.SugaredLogger) (route *RouteT, err error) {
+
+	config.Options = mergeOptions(config.Options, proxyConfig.Options)
+	if reflect.ValueOf(config.HashKey).IsZero() {
+		config.HashKey = proxyConfig.HashKey
+	}
+
+	route = &RouteT{
+		Config: config,
+		Load:   NewLoadTracker(),
+		Logger: logger.With("route", config.Name),
+	}
+
+	if reflect.ValueOf(config.Backends.Dns).IsZero() && reflect.ValueOf(config.Backends.Static).IsZero() {
+		return nil, fmt.Errorf("backends not defined")
+	}
+
+	if !reflect.ValueOf(config.Backends.Dns).IsZero() && !reflect.ValueOf(config.Backends.Static).IsZero() {
+		return nil, fmt.Errorf("failed to load backends: static and dns are mutually exclusive")
+	}
+
+	switch config.Backends.Dns.Type {
+	case "", DnsTypeA, DnsTypeSrv:
+	default:
+		return nil, fmt.Errorf("unknown dns type '%s'", config.Backends.Dns.Type)
+	}
+
+	staticNames := map[string]struct{}{}
+	for _, backend := range config.Backends.Static {
+
+		// Backends sharing the name would be merged into the same member of the hashring
+		backendName := getStaticBackendName(backend)
+		if _, found := staticNames[backendName]; found {
+			return nil, fmt.Errorf("backend name '%s' is duplicated", backendName)
+		}
+		staticNames[backendName] = struct{}{}
+
+		if backend.Weight > hashring.MaxWeight {
+			return nil, fmt.Errorf("weight of backend '%s' can not exceed %d", backend.Host, hashring.MaxWeight)
+		}
+
+		if err = validateHealthCheck(backend.HealthCheck); err != nil {
+			return nil, fmt.Errorf("invalid healthcheck for backend '%s': %s", backend.Host, err.Error())
+		}
+	}
+
+	for _, weightConfig := range config.Backends.Dns.Weights {
+		if weightConfig.Weight > hashring.MaxWeight {
+			return nil, fmt.Errorf("weight of dns address '%s' can not exceed %d", weightConfig.Address, hashring.MaxWeight)
+		}
+	}
+
+	if err = validateHealthCheck(config.Backends.Dns.HealthCheck); err != nil {
+		return nil, fmt.Errorf("invalid healthcheck for dns backends: %s", err.Error())
+	}
+
+	route.SyncTime, err = time.ParseDuration(config.Backends.Synchronization)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing backend synchronization time: %s", err.Error())
+	}
+
+	route.matcher, err = newRouteMatcher(config.Match)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling match conditions: %s", err.Error())
+	}
+
+	// Compatibility modes place the backends by their name, so it must be named as in libketama or nginx.
+	// DNS backends are named by their slots, so placements could never match
+	if config.HashKey.Algorithm == hashring.AlgorithmKetama || config.HashKey.Algorithm == hashring.AlgorithmNginx {
+		if !reflect.ValueOf(config.Backends.Dns).IsZero() {
+			return nil, fmt.Errorf("hashring algorithm '%s' is not compatible with dns backends", config.HashKey.Algorithm)
+		}
+
+		for _, backend := range config.Backends.Static {
+			backendName := getStaticBackendName(backend)
+			if _, port, err := net.SplitHostPort(backendName); err != nil || port == "" {
+				return nil, fmt.Errorf("backend name '%s' must be 'host:port' for hashring algorithm '%s'",
+					backendName, config.HashKey.Algorithm)
+			}
+		}
+	}
+
+	route.Hashring, err = hashring.NewHashRing(config.HashKey.Algorithm, config.HashKey.HashFunction)
+	if err != nil {
+		return nil, fmt.Errorf("error creating hashring: %s", err.Error())
+	}
+
+	route.HashKey, err = NewHashKeyCalculator(config.HashKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating hash_key calculator: %s", err.Error())
+	}
+
+	switch config.Options.HttpBackendProtocol {
+	case "", BackendProtocolHttp1, BackendProtocolH2, BackendProtocolH2c:
+	default:
+		return nil, fmt.Errorf("unknown backend protocol '%s'", config.Options.HttpBackendProtocol)
+	}
+
+	switch config.Options.HostHeader {
+	case "", HostHeaderPreserve, HostHeaderBackend:
+	case HostHeaderFixed:
+		if config.Options.HostHeaderValue == "" {
+			return nil, fmt.Errorf("host header mode '%s' requires host_header_value", HostHeaderFixed)
+		}
+	default:
+		return nil, fmt.Errorf("unknown host header mode '%s'", config.Options.HostHeader)
+	}
+
+	route.RetryPolicy, err = NewRetryPolicy(config.Options)
+	if err != nil {
+		return nil, fmt.Errorf("error creating retry policy: %s", err.Error())
+	}
+
+	route.Outliers, err = NewOutlierDetector(config.Options.OutlierDetection)
+	if err != nil {
+		return nil, fmt.Errorf("error creating outlier detector: %s", err.Error())
+	}
+
+	route.Breakers, err = NewCircuitBreaker(config.Options.CircuitBreaker)
+	if err != nil {
+		return nil, fmt.Errorf("error creating circuit breaker: %s", err.Error())
+	}
+
+	route.TrustedProxies, err = ParseTrustedProxies(config.Options.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing trusted proxies: %s", err.Error())
+	}
+
+	return route, nil
+}
+
+// Matches returns whether the given request meets all the match conditions of the route
+func (r *RouteT) Matches(req *http.Request) bool {
+	return r.matcher.matches(req)
+}
+
+// mergeOptions returns the route options with the fields not set taken from the proxy options.
+// Nested structs are merged field by field, and flags are pointers, so setting them to false is not ignored
+func mergeOptions(routeOptions api.OptionsT, proxyOptions api.OptionsT) (merged api.OptionsT) {
+
+	merged = routeOptions
+	mergeFields(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(proxyOptions))
+
+	return merged
+}
+
+// mergeFields sets the zero fields of the 'merged' struct to the ones of the 'fallback' struct, recursively
+func mergeFields(merged reflect.Value, fallback reflect.Value) {
+	for i := 0; i < merged.NumField(); i++ {
+		if merged.Field(i).Kind() == reflect.Struct {
+			mergeFields(merged.Field(i), fallback.Field(i))
+			continue
+		}
+
+		if merged.Field(i).IsZero() {
+			merged.Field(i).Set(fallback.Field(i))
+		}
+	}
+}
+
+// isEnabled returns whether the given flag is set to true
+func isEnabled(flag *bool) bool {
+	return flag != nil && *flag
+}
+
+// newRouteMatcher returns the compiled version of the given match conditions
+func newRouteMatcher(config api.RouteMatchT) (matcher *routeMatcherT, err error) {
+
+	matcher = &routeMatcherT{
+		pathPrefix: config.PathPrefix,
+	}
+
+	for _, host := range config.Hosts {
+		matcher.hosts = append(matcher.hosts, strings.ToLower(host))
+	}
+
+	for _, method := range config.Methods {
+		matcher.methods = append(matcher.methods, strings.ToUpper(method))
+	}
+
+	if config.PathRegex != "" {
+		matcher.pathRegex, err = regexp.Compile(config.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling path regex '%s': %s", config.PathRegex, err.Error())
+		}
+	}
+
+	for _, header := range config.Headers {
+		if header.Name == "" {
+			return nil, fmt.Errorf("header name can not be empty")
+		}
+
+		if header.Value != "" && header.Regex != "" {
+			return nil, fmt.Errorf("header '%s' value and regex are mutually exclusive", header.Name)
+		}
+
+		headerMatcher := routeHeaderMatcherT{
+			name:  header.Name,
+			value: header.Value,
+		}
+
+		if header.Regex != "" {
+			headerMatcher.regex, err = regexp.Compile(header.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("error compiling header '%s' regex '%s': %s", header.Name, header.Regex, err.Error())
+			}
+		}
+
+		matcher.headers = append(matcher.headers, headerMatcher)
+	}
+
+	return matcher, nil
+}
+
+// matches returns whether the given request meets all the match conditions
+func (m *routeMatcherT) matches(req *http.Request) bool {
+
+	if len(m.hosts) > 0 && !m.matchesHost(req.Host) {
+		return false
+	}
+
+	if m.pathPrefix != "" && !strings.HasPrefix(req.URL.Path, m.pathPrefix) {
+		return false
+	}
+
+	if m.pathRegex != nil && !m.pathRegex.MatchString(req.URL.Path) {
+		return false
+	}
+
+	if len(m.methods) > 0 && !slices.Contains(m.methods, req.Method) {
+		return false
+	}
+
+	for _, header := range m.headers {
+		values := req.Header.Values(header.name)
+		if len(values) == 0 {
+			return false
+		}
+
+		if header.value != "" && !slices.Contains(values, header.value) {
+			return false
+		}
+
+		if header.regex != nil && !slices.ContainsFunc(values, header.regex.MatchString) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesHost returns whether the given host, with or without port, is one of the matcher hosts.
+// Hosts starting with '*.' match any subdomain of the rest
+func (m *routeMatcherT) matchesHost(host string) bool {
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+
+	for _, candidate := range m.hosts {
+		if candidate == host {
+			return true
+		}
+
+		if suffix, found := strings.CutPrefix(candidate, "*"); found && strings.HasPrefix(suffix, ".") &&
+			strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getBackendCandidates returns up to 'n' backends to try for the given key, in ring order starting by the due one,
+// so the fallbacks are stable for each key and spread across the whole ring.
+// Backends rejected by 'isSelectable' are skipped, unless all of them are
+func getBackendCandidates(snapshot *hashring.Snapshot, key string, dueBackend string, n int,
+	isSelectable func(server string) bool) (candidates []string) {
+
+	collect := func(filter func(server string) bool) (candidates []string) {
+		if n <= 0 {
+			return candidates
+		}
+
+		// The due backend can be out of the ring, when it is forced by the configuration
+		if snapshot.GetServerAddress(dueBackend) != "" && filter(dueBackend) {
+			candidates = append(candidates, dueBackend)
+		}
+
+		if len(candidates) == n {
+			return candidates
+		}
+
+		snapshot.Walk(key, func(server string) bool {
+			if server == dueBackend || !filter(server) {
+				return true
+			}
+
+			candidates = append(candidates, server)
+			return len(candidates) < n
+		})
+		return candidates
+	}
+
+	candidates = collect(isSelectable)
+	if len(candidates) == 0 {
+		candidates = collect(func(server string) bool { return true })
+	}
+	return candidates
+}
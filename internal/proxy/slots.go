@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"slices"
+	"strconv"
+	"time"
+)
+
+// SlotAllocatorT assigns ordinal slots to the addresses discovered by DNS.
+// Slots are the identity of the backends in the hashring, so a backend coming back
+// with a different address (i.e. a restarted pod) keeps the same arc of the ring
+// when it appears within the grace period of the slot it left
+type SlotAllocatorT struct {
+	prefix      string
+	gracePeriod time.Duration
+
+	// Position is the ordinal of the slot. Nil positions are free
+	slots []*slotT
+}
+
+type slotT struct {
+	address  string
+	lastSeen time.Time
+}
+
+// NewSlotAllocator returns a new SlotAllocatorT whose identities are named '<prefix>-<ordinal>'
+func NewSlotAllocator(prefix string, gracePeriod time.Duration) *SlotAllocatorT {
+	return &SlotAllocatorT{
+		prefix:      prefix,
+		gracePeriod: gracePeriod,
+	}
+}
+
+// Assign returns the identity for each one of the given addresses.
+// Addresses keep their slot while they are discovered. New addresses take first the slots
+// left by missing addresses within the grace period, then the free slots with lowest ordinal
+func (s *SlotAllocatorT) Assign(addresses []string, now time.Time) (identities map[string]string) {
+	identities = make(map[string]string, len(addresses))
+
+	// Free the slots vacant for longer than the grace period
+	for ordinal, slot := range s.slots {
+		if slot != nil && !slices.Contains(addresses, slot.address) && now.Sub(slot.lastSeen) > s.gracePeriod {
+			s.slots[ordinal] = nil
+		}
+	}
+
+	// Known addresses keep their slot
+	pendingAddresses := []string{}
+	for _, address := range addresses {
+		ordinal := slices.IndexFunc(s.slots, func(slot *slotT) bool {
+			return slot != nil && slot.address == address
+		})
+
+		if ordinal == -1 {
+			pendingAddresses = append(pendingAddresses, address)
+			continue
+		}
+
+		s.slots[ordinal].lastSeen = now
+		identities[address] = s.identity(ordinal)
+	}
+
+	// Sorting is performed to assign the same slots when the same addresses are discovered
+	slices.Sort(pendingAddresses)
+	for _, address := range pendingAddresses {
+		ordinal := slices.IndexFunc(s.slots, func(slot *slotT) bool {
+			return slot != nil && !slices.Contains(addresses, slot.address)
+		})
+
+		if ordinal == -1 {
+			ordinal = slices.Index(s.slots, nil)
+		}
+
+		if ordinal == -1 {
+			s.slots = append(s.slots, nil)
+			ordinal = len(s.slots) - 1
+		}
+
+		s.slots[ordinal] = &slotT{address: address, lastSeen: now}
+		identities[address] = s.identity(ordinal)
+	}
+
+	return identities
+}
+
+// identity returns the name of the slot with the given ordinal
+func (s *SlotAllocatorT) identity(ordinal int) string {
+	return s.prefix + "-" + strconv.Itoa(ordinal)
+}
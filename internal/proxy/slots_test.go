@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+// TestSlotAllocatorAssign checks the identities assigned over successive discoveries
+func TestSlotAllocatorAssign(t *testing.T) {
+	type stepT struct {
+		elapsed    time.Duration
+		addresses  []string
+		identities map[string]string
+	}
+
+	tests := []struct {
+		name  string
+		steps []stepT
+	}{
+		{
+			name: "known addresses keep their slot",
+			steps: []stepT{
+				{0, []string{"10.0.0.2", "10.0.0.1"}, map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-1"}},
+				{time.Minute, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+					map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-1", "10.0.0.3": "dns-2"}},
+			},
+		},
+		{
+			name: "address coming back within the grace period",
+			steps: []stepT{
+				{0, []string{"10.0.0.1", "10.0.0.2"}, map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-1"}},
+				{time.Minute, []string{"10.0.0.2"}, map[string]string{"10.0.0.2": "dns-1"}},
+				{2 * time.Minute, []string{"10.0.0.1", "10.0.0.2"}, map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-1"}},
+			},
+		},
+		{
+			name: "address replaced within the grace period",
+			steps: []stepT{
+				{0, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+					map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-1", "10.0.0.3": "dns-2"}},
+				{time.Minute, []string{"10.0.0.1", "10.0.0.3"}, map[string]string{"10.0.0.1": "dns-0", "10.0.0.3": "dns-2"}},
+				{2 * time.Minute, []string{"10.0.0.1", "10.0.0.3", "10.0.0.9"},
+					map[string]string{"10.0.0.1": "dns-0", "10.0.0.3": "dns-2", "10.0.0.9": "dns-1"}},
+
+				// The replaced address gets a new slot when it comes back
+				{3 * time.Minute, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.9"},
+					map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-3", "10.0.0.3": "dns-2", "10.0.0.9": "dns-1"}},
+			},
+		},
+		{
+			name: "vacant slots are taken before free ones",
+			steps: []stepT{
+				{0, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+					map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-1", "10.0.0.3": "dns-2"}},
+				{4 * time.Minute, []string{"10.0.0.2", "10.0.0.3"}, map[string]string{"10.0.0.2": "dns-1", "10.0.0.3": "dns-2"}},
+
+				// The slot of 10.0.0.1 is free, while the one of 10.0.0.3 is still reserved
+				{6 * time.Minute, []string{"10.0.0.2", "10.0.0.4"}, map[string]string{"10.0.0.2": "dns-1", "10.0.0.4": "dns-2"}},
+			},
+		},
+		{
+			name: "address replaced after the grace period",
+			steps: []stepT{
+				{0, []string{"10.0.0.1", "10.0.0.2"}, map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-1"}},
+				{time.Minute, []string{"10.0.0.2"}, map[string]string{"10.0.0.2": "dns-1"}},
+				{7 * time.Minute, []string{"10.0.0.2"}, map[string]string{"10.0.0.2": "dns-1"}},
+
+				// The expired slot is free, so it is taken by the lowest new address
+				{8 * time.Minute, []string{"10.0.0.2", "10.0.0.8", "10.0.0.7"},
+					map[string]string{"10.0.0.2": "dns-1", "10.0.0.7": "dns-0", "10.0.0.8": "dns-2"}},
+
+				// The expired address does not get its slot back
+				{9 * time.Minute, []string{"10.0.0.1", "10.0.0.2", "10.0.0.7", "10.0.0.8"},
+					map[string]string{"10.0.0.1": "dns-3", "10.0.0.2": "dns-1", "10.0.0.7": "dns-0", "10.0.0.8": "dns-2"}},
+			},
+		},
+		{
+			name: "several addresses replaced at once",
+			steps: []stepT{
+				{0, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+					map[string]string{"10.0.0.1": "dns-0", "10.0.0.2": "dns-1", "10.0.0.3": "dns-2", "10.0.0.4": "dns-3"}},
+
+				// New addresses take the vacant slots sorted, and the remaining one is appended
+				{time.Minute, []string{"10.0.0.4", "10.0.0.7", "10.0.0.1", "10.0.0.6", "10.0.0.5"},
+					map[string]string{"10.0.0.1": "dns-0", "10.0.0.4": "dns-3", "10.0.0.5": "dns-1", "10.0.0.6": "dns-2", "10.0.0.7": "dns-4"}},
+			},
+		},
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allocator := NewSlotAllocator("dns", 5*time.Minute)
+
+			for index, step := range test.steps {
+				identities := allocator.Assign(step.addresses, start.Add(step.elapsed))
+				if !maps.Equal(identities, step.identities) {
+					t.Fatalf("step %d: got %v, want %v", index, identities, step.identities)
+				}
+			}
+		})
+	}
+}
@@ -4,119 +4,288 @@
 package proxy
 
 import (
-	"fmt"
+	"math"
 	"net"
-	"net/http"
 	"reflect"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"hashrouter/api"
 	"hashrouter/internal/hashring"
 )
 
+const (
+
+	// Time a DNS slot is kept for its missing address before it can be reused by others
+	defaultDnsSlotGracePeriod = 5 * time.Minute
+
+	// Types of DNS discovery
+	DnsTypeA   = "a"
+	DnsTypeSrv = "srv"
+
+	// Ring weight given to the SRV targets with the highest weight of their priority.
+	// The rest are scaled proportionally, so big SRV weights do not blow the hashring up
+	srvMaxRingWeight = 10
+)
+
 // TODO
 type BackendT struct {
+
+	// Name is the stable identity of the backend in the hashring, while Host is the address to dial
+	Name   string
 	Host   string
+	Weight int
 	Health api.HealthCheckT
+
+	// Tier groups the backends by preference. Only the healthy ones of the lowest tier are in the hashring
+	Tier int
 }
 
-// TODO
-func (p *ProxyT) Synchronizer(syncTime time.Duration) {
-	p.Hashring = hashring.NewHashRing(1000)
+// getDnsBackendWeight returns the weight configured for a DNS-discovered IP.
+// The first matching entry wins. When no entry matches, the default weight is returned
+func (r *RouteT) getDnsBackendWeight(ip net.IP) int {
+	for _, weightConfig := range r.Config.Backends.Dns.Weights {
 
-	for {
-		tmpHostPool := []BackendT{}
-		hostPool := []string{}
-
-		// STATIC ---
-		if !reflect.ValueOf(p.SelfConfig.Backends.Static).IsZero() {
-			for _, backend := range p.SelfConfig.Backends.Static {
-				tmpHostPool = append(tmpHostPool, BackendT{
-					Host:   backend.Host,
-					Health: backend.HealthCheck,
-				})
+		if strings.Contains(weightConfig.Address, "/") {
+			_, network, err := net.ParseCIDR(weightConfig.Address)
+			if err != nil {
+				r.Logger.Errorf("error parsing weight address '%s': %s", weightConfig.Address, err.Error())
+				continue
 			}
+
+			if network.Contains(ip) {
+				return weightConfig.Weight
+			}
+			continue
 		}
 
-		// DNS ---
-		if !reflect.ValueOf(p.SelfConfig.Backends.Dns).IsZero() {
+		if ip.Equal(net.ParseIP(weightConfig.Address)) {
+			return weightConfig.Weight
+		}
+	}
 
-			p.Logger.Infof("syncing hashring with DNS")
+	return hashring.DefaultWeight
+}
 
-			discoveredIps, err := net.LookupIP(p.SelfConfig.Backends.Dns.Domain)
-			if err != nil {
-				p.Logger.Errorf("error looking up %s: %s", p.SelfConfig.Backends.Dns.Domain, err.Error())
-			}
+// Synchronizer keeps the hashring of the route updated with the healthy backends.
+// Backends are discovered periodically, while their health is read from the health checker,
+// applying its changes as soon as they happen. Intended to be run as a goroutine
+func (r *RouteT) Synchronizer() {
 
-			for _, discoveredIp := range discoveredIps {
+	// DNS-discovered backends are identified by ordinal slots, as their addresses can change
+	dnsSlotPrefix := r.Config.Backends.Dns.Name
+	if dnsSlotPrefix == "" {
+		dnsSlotPrefix = r.Config.Backends.Dns.Domain
+	}
 
-				hostAddress := discoveredIp.String() + ":" + strconv.Itoa(p.SelfConfig.Backends.Dns.Port)
-				if IsIPv6(discoveredIp.String()) {
-					hostAddress = "[" + hostAddress + "]" + ":" + strconv.Itoa(p.SelfConfig.Backends.Dns.Port)
-				}
+	dnsSlotGracePeriod := defaultDnsSlotGracePeriod
+	if r.Config.Backends.Dns.SlotGracePeriod > 0 {
+		dnsSlotGracePeriod = r.Config.Backends.Dns.SlotGracePeriod
+	}
 
-				tmpHostPool = append(tmpHostPool, BackendT{
-					Host:   hostAddress,
-					Health: p.SelfConfig.Backends.Dns.HealthCheck,
-				})
+	dnsSlots := NewSlotAllocator(dnsSlotPrefix, dnsSlotGracePeriod)
 
-			}
+	syncTimer := time.NewTimer(0)
+	defer syncTimer.Stop()
+
+	var backendPool []BackendT
+	for {
+		select {
+		case <-syncTimer.C:
+			backendPool = r.discoverBackends(dnsSlots)
+			r.HealthChecker.Update(backendPool)
+			syncTimer.Reset(r.SyncTime)
+
+		// Health changes are applied to the last discovered backends, with no need to discover them again
+		case <-r.HealthChecker.Changes():
 		}
 
-		//
-		hClient := http.Client{}
-		for _, backend := range tmpHostPool {
-			if reflect.ValueOf(backend.Health).IsZero() {
-				hostPool = append(hostPool, backend.Host)
-				continue
-			}
+		r.applyBackends(backendPool)
+	}
+}
 
-			//
-			hClient.Timeout = backend.Health.Timeout
-			for i := 0; i < backend.Health.Retries; i++ {
-				resp, err := hClient.Get(fmt.Sprintf("http://%s%s", backend.Host, backend.Health.Path))
-				if err == nil && resp.StatusCode == 200 {
-					hostPool = append(hostPool, backend.Host)
-					break
-				}
-
-				if err != nil {
-					p.Logger.Errorf("unable to perform healthcheck on host '%s': %s", backend.Host, err.Error())
-					continue
-				}
-
-				p.Logger.Errorf("healthcheck failed for host '%s' with status '%s'", backend.Host, resp.Status)
-			}
+// discoverBackends returns the backends of the route, either static or discovered by DNS
+func (r *RouteT) discoverBackends(dnsSlots *SlotAllocatorT) (backendPool []BackendT) {
+
+	// STATIC ---
+	if !reflect.ValueOf(r.Config.Backends.Static).IsZero() {
+		for _, backend := range r.Config.Backends.Static {
+			backendPool = append(backendPool, BackendT{
+				Name:   getStaticBackendName(backend),
+				Host:   backend.Host,
+				Weight: backend.Weight,
+				Health: backend.HealthCheck,
+			})
+		}
+	}
+
+	// DNS ---
+	if !reflect.ValueOf(r.Config.Backends.Dns).IsZero() {
+
+		r.Logger.Infof("syncing hashring with DNS")
+
+		var discoveredBackends []BackendT
+		var err error
+		if r.Config.Backends.Dns.Type == DnsTypeSrv {
+			discoveredBackends, err = r.lookupSrvBackends()
+		} else {
+			discoveredBackends, err = r.lookupIpBackends()
+		}
+
+		if err != nil {
+			r.Logger.Errorf("error looking up %s: %s", r.Config.Backends.Dns.Domain, err.Error())
 		}
 
-		currentServerList := p.Hashring.GetServerList()
+		discoveredAddresses := []string{}
+		for _, backend := range discoveredBackends {
+			discoveredAddresses = append(discoveredAddresses, backend.Host)
+		}
 
-		deleteServersList := []string{}
-		for _, server := range currentServerList {
-			if !slices.Contains(hostPool, server) {
-				deleteServersList = append(deleteServersList, server)
-			}
+		// Slots are not assigned when the lookup fails, to keep them for the missing addresses
+		var identities map[string]string
+		if err == nil {
+			identities = dnsSlots.Assign(discoveredAddresses, time.Now())
 		}
 
-		appendServersList := []string{}
-		for _, server := range hostPool {
-			if !slices.Contains(currentServerList, server) {
-				appendServersList = append(appendServersList, server)
-			}
+		for _, backend := range discoveredBackends {
+			backend.Name = identities[backend.Host]
+			backend.Health = r.Config.Backends.Dns.HealthCheck
+			backendPool = append(backendPool, backend)
+		}
+	}
+
+	return backendPool
+}
+
+// applyBackends updates the hashring with the healthy ones of the given backends.
+// Backends without health check configuration are always considered healthy
+func (r *RouteT) applyBackends(backendPool []BackendT) {
+
+	healthyBackends := []BackendT{}
+	for _, backend := range backendPool {
+		if !reflect.ValueOf(backend.Health).IsZero() && !r.HealthChecker.IsHealthy(backend.Host) {
+			continue
 		}
+		healthyBackends = append(healthyBackends, backend)
+	}
 
-		for _, server := range appendServersList {
-			p.Hashring.AddServer(server)
+	// Standby tiers are only used when there are no healthy backends in the preferred ones.
+	// Backends without health checks are always healthy, so their tier is never left
+	preferredTier := 0
+	if len(healthyBackends) > 0 {
+		preferredTier = slices.MinFunc(healthyBackends, func(a, b BackendT) int { return a.Tier - b.Tier }).Tier
+	}
+
+	hostPool := []hashring.Member{}
+	for _, backend := range healthyBackends {
+		if backend.Tier != preferredTier {
+			continue
 		}
 
-		for _, server := range deleteServersList {
-			p.Hashring.RemoveServer(server)
+		member := hashring.Member{
+			Name:    backend.Name,
+			Address: backend.Host,
+			Weight:  backend.Weight,
+		}
+		if member.Weight < 1 {
+			member.Weight = hashring.DefaultWeight
 		}
+		hostPool = append(hostPool, member)
+	}
+
+	currentSnapshot := r.Hashring.Snapshot()
 
-		p.Logger.Infof("current hashring: %s", p.Hashring.String())
+	deleteServersList := []string{}
+	for _, server := range currentSnapshot.GetServerList() {
+		if !slices.ContainsFunc(hostPool, func(member hashring.Member) bool { return member.Name == server }) {
+			deleteServersList = append(deleteServersList, server)
+		}
+	}
+
+	// Servers whose weight or address changed are added again to update them
+	appendServersList := []hashring.Member{}
+	for _, member := range hostPool {
+		if currentSnapshot.GetServerWeight(member.Name) != member.Weight ||
+			currentSnapshot.GetServerAddress(member.Name) != member.Address {
+			appendServersList = append(appendServersList, member)
+		}
+	}
+
+	if len(appendServersList) == 0 && len(deleteServersList) == 0 {
+		return
+	}
+
+	// The whole membership change is applied at once, building the new hashring
+	// out of the request path
+	r.Hashring.ApplyMembership(appendServersList, deleteServersList)
+	r.Connections.Prune()
+
+	r.Logger.Infof("current hashring: %s", r.Hashring.String())
+}
 
-		time.Sleep(syncTime)
+// getStaticBackendName returns the identity of the given static backend in the hashring.
+// Backends without name are identified by their host
+func getStaticBackendName(backend api.BackendsStaticT) string {
+	if backend.Name == "" {
+		return backend.Host
 	}
+	return backend.Name
+}
+
+// lookupIpBackends returns the backends resolved from the A/AAAA records of the domain,
+// all of them listening on the configured port
+func (r *RouteT) lookupIpBackends() (backends []BackendT, err error) {
+
+	discoveredIps, err := net.LookupIP(r.Config.Backends.Dns.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, discoveredIp := range discoveredIps {
+		backends = append(backends, BackendT{
+			Host:   net.JoinHostPort(discoveredIp.String(), strconv.Itoa(r.Config.Backends.Dns.Port)),
+			Weight: r.getDnsBackendWeight(discoveredIp),
+		})
+	}
+
+	return backends, nil
+}
+
+// lookupSrvBackends returns the backends resolved from the SRV records of the domain ('_service._proto.name').
+// Each target uses its own port, its priority as tier, and its weight scaled among those of the same priority
+func (r *RouteT) lookupSrvBackends() (backends []BackendT, err error) {
+
+	_, records, err := net.LookupSRV("", "", r.Config.Backends.Dns.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// A target of "." means the service is not available on this domain (RFC 2782)
+	records = slices.DeleteFunc(records, func(record *net.SRV) bool {
+		return record.Target == "."
+	})
+
+	maxWeights := map[uint16]uint16{}
+	for _, record := range records {
+		maxWeights[record.Priority] = max(maxWeights[record.Priority], record.Weight)
+	}
+
+	for _, record := range records {
+
+		// Targets with weight 0 get the minimum weight, as they must still be selectable
+		weight := hashring.DefaultWeight
+		if maxWeight := maxWeights[record.Priority]; maxWeight > 0 {
+			weight = max(int(math.Round(float64(record.Weight)*srvMaxRingWeight/float64(maxWeight))), hashring.DefaultWeight)
+		}
+
+		backends = append(backends, BackendT{
+			Host:   net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))),
+			Weight: weight,
+			Tier:   int(record.Priority),
+		})
+	}
+
+	return backends, nil
 }
@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"hashrouter/api"
+
+	"go.uber.org/zap"
+)
+
+const (
+
+	// Time between checks of the certificate files looking for changes
+	// (default: 10s)
+	defaultTlsReloadInterval = 10 * time.Second
+
+	// Client certificate verification modes
+	TlsClientAuthRequire       = "require"
+	TlsClientAuthVerifyIfGiven = "verify_if_given"
+)
+
+var (
+
+	// Versions available for 'tls_min_version'
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// CertificateStoreT holds the certificates served by a listener.
+// They are reloaded from disk when their files change, so they can be rotated without restarting
+type CertificateStoreT struct {
+	sync.RWMutex
+
+	//
+	pairs        []api.TlsCertificateT
+	certificates []*tls.Certificate
+	modTimes     []time.Time
+
+	//
+	Logger *zap.SugaredLogger
+}
+
+// NewCertificateStore returns a CertificateStoreT with the given pairs already loaded
+func NewCertificateStore(pairs []api.TlsCertificateT, logger *zap.SugaredLogger) (store *CertificateStoreT, err error) {
+
+	store = &CertificateStoreT{
+		pairs:        pairs,
+		certificates: make([]*tls.Certificate, len(pairs)),
+		modTimes:     make([]time.Time, len(pairs)),
+		Logger:       logger,
+	}
+
+	for index := range pairs {
+		if _, err = store.reload(index); err != nil {
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// GetCertificate returns the certificate to be served for the given handshake.
+// The first certificate supporting the client (SNI, signature algorithms...) is selected,
+// or the first one of the list when none of them does
+func (s *CertificateStoreT) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, certificate := range s.certificates {
+		if hello.SupportsCertificate(certificate) == nil {
+			return certificate, nil
+		}
+	}
+
+	return s.certificates[0], nil
+}
+
+// Watch reloads the certificates whose files changed, checking them periodically.
+// Intended to be run as a goroutine
+func (s *CertificateStoreT) Watch(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		for index, pair := range s.pairs {
+			reloaded, err := s.reload(index)
+			if err != nil {
+				s.Logger.Errorf("error reloading TLS certificate '%s', keeping the previous one: %s",
+					pair.Certificate, err.Error())
+				continue
+			}
+
+			if reloaded {
+				s.Logger.Infof("TLS certificate '%s' reloaded", pair.Certificate)
+			}
+		}
+	}
+}
+
+// reload loads the pair at the given index from disk when its files changed since the last load
+func (s *CertificateStoreT) reload(index int) (reloaded bool, err error) {
+
+	pair := s.pairs[index]
+
+	// The latest modification of both files is tracked, as they can be rotated separately
+	var modTime time.Time
+	for _, path := range []string{pair.Certificate, pair.Key} {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+
+		if fileInfo.ModTime().After(modTime) {
+			modTime = fileInfo.ModTime()
+		}
+	}
+
+	s.RLock()
+	unchanged := s.certificates[index] != nil && modTime.Equal(s.modTimes[index])
+	s.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(pair.Certificate, pair.Key)
+	if err != nil {
+		return false, fmt.Errorf("error loading certificate '%s' with key '%s': %s",
+			pair.Certificate, pair.Key, err.Error())
+	}
+
+	s.Lock()
+	s.certificates[index] = &certificate
+	s.modTimes[index] = modTime
+	s.Unlock()
+
+	return true, nil
+}
+
+// BuildTlsConfig creates the TLS configuration of the listener when any certificate is configured.
+// Certificates are selected by SNI, the one defined in 'tls_certificate' being the default
+func (p *ProxyT) BuildTlsConfig() (err error) {
+
+	options := p.SelfConfig.Options
+
+	pairs := []api.TlsCertificateT{}
+	if options.TlsCertificate != "" || options.TlsKey != "" {
+		pairs = append(pairs, api.TlsCertificateT{
+			Certificate: options.TlsCertificate,
+			Key:         options.TlsKey,
+		})
+	}
+	pairs = append(pairs, options.TlsCertificates...)
+
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	p.TlsCertificates, err = NewCertificateStore(pairs, p.Logger)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: p.TlsCertificates.GetCertificate,
+	}
+
+	//
+	if options.TlsMinVersion != "" {
+		minVersion, found := tlsVersions[options.TlsMinVersion]
+		if !found {
+			return fmt.Errorf("unknown TLS version '%s'", options.TlsMinVersion)
+		}
+		tlsConfig.MinVersion = minVersion
+	}
+
+	// Only the secure suites are available. They apply to TLS 1.2 and lower, as TLS 1.3 ones are not configurable
+	for _, cipherSuiteName := range options.TlsCipherSuites {
+		var cipherSuiteId uint16
+		for _, cipherSuite := range tls.CipherSuites() {
+			if cipherSuite.Name == cipherSuiteName {
+				cipherSuiteId = cipherSuite.ID
+				break
+			}
+		}
+
+		if cipherSuiteId == 0 {
+			return fmt.Errorf("unknown or insecure TLS cipher suite '%s'", cipherSuiteName)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, cipherSuiteId)
+	}
+
+	//
+	if options.TlsClientAuth != "" && options.TlsClientCa == "" {
+		return fmt.Errorf("TLS client auth mode '%s' requires tls_client_ca", options.TlsClientAuth)
+	}
+
+	if options.TlsClientCa != "" {
+		caContent, err := os.ReadFile(options.TlsClientCa)
+		if err != nil {
+			return fmt.Errorf("error reading TLS client CA: %s", err.Error())
+		}
+
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(caContent) {
+			return fmt.Errorf("no certificates found in TLS client CA '%s'", options.TlsClientCa)
+		}
+
+		switch options.TlsClientAuth {
+		case TlsClientAuthRequire, "":
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		case TlsClientAuthVerifyIfGiven:
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return fmt.Errorf("unknown TLS client auth mode '%s'", options.TlsClientAuth)
+		}
+	}
+
+	p.TlsConfig = tlsConfig
+	return nil
+}
+
+// WatchTlsCertificates reloads the certificates of the listener when their files change.
+// Intended to be run as a goroutine
+func (p *ProxyT) WatchTlsCertificates() {
+	if p.TlsCertificates == nil {
+		return
+	}
+
+	reloadInterval := defaultTlsReloadInterval
+	if p.SelfConfig.Options.TlsReloadInterval > 0 {
+		reloadInterval = p.SelfConfig.Options.TlsReloadInterval
+	}
+
+	p.TlsCertificates.Watch(reloadInterval)
+}
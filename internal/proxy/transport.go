@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2026 Alby Hernández <hola@achetronic.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"hashrouter/internal/hashring"
+	"hashrouter/internal/metrics"
+)
+
+// ConnectionTrackerT keeps the amount of connections open to each backend address of a route.
+// The gauges of an address are deleted once all its connections are closed and it is not in the hashring,
+// so the addresses replaced over time do not keep their series forever
+type ConnectionTrackerT struct {
+	sync.Mutex
+
+	//
+	hashring *hashring.HashRing
+	labels   prometheus.Labels
+	meter    *metrics.PoolT
+
+	//
+	connections map[string]int
+}
+
+// trackedConnT is a backend connection reporting its state to the connection gauges.
+// Connections are open from their dial to their close, and idle while waiting in the pool of the transport.
+// Idle state is only reported by HTTP/1 transports, as HTTP/2 ones keep their connections out of the trace
+type trackedConnT struct {
+	net.Conn
+
+	//
+	address   string
+	tracker   *ConnectionTrackerT
+	idle      atomic.Bool
+	closeOnce sync.Once
+
+	//
+	openGauge prometheus.Gauge
+	idleGauge prometheus.Gauge
+}
+
+// NewConnectionTracker returns a ConnectionTrackerT reporting the connections of the given route
+func NewConnectionTracker(proxyName string, routeName string, ring *hashring.HashRing, meter *metrics.PoolT) *ConnectionTrackerT {
+	return &ConnectionTrackerT{
+		hashring: ring,
+		labels: prometheus.Labels{
+			"proxy_name": proxyName,
+			"route":      routeName,
+		},
+		meter:       meter,
+		connections: make(map[string]int),
+	}
+}
+
+// track wraps the given connection to the given address, so it is reported in the connection gauges
+func (t *ConnectionTrackerT) track(conn net.Conn, address string) *trackedConnT {
+	t.Lock()
+	defer t.Unlock()
+
+	labels := t.getLabels(address)
+	trackedConn := &trackedConnT{
+		Conn:      conn,
+		address:   address,
+		tracker:   t,
+		openGauge: t.meter.BackendOpenConnections.With(labels),
+		idleGauge: t.meter.BackendIdleConnections.With(labels),
+	}
+	trackedConn.openGauge.Inc()
+	t.connections[address]++
+
+	return trackedConn
+}
+
+// release registers a closed connection to the given address,
+// deleting its gauges when it was the last one and the address is not in the hashring
+func (t *ConnectionTrackerT) release(address string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.connections[address]--
+	if t.connections[address] == 0 && !t.isInHashring(address) {
+		t.delete(address)
+	}
+}
+
+// Prune deletes the gauges of the addresses without connections that are not in the hashring.
+// It must be called after the hashring changes, as the connections may be closed before it
+func (t *ConnectionTrackerT) Prune() {
+	t.Lock()
+	defer t.Unlock()
+
+	for address, connections := range t.connections {
+		if connections == 0 && !t.isInHashring(address) {
+			t.delete(address)
+		}
+	}
+}
+
+// isInHashring returns whether any server of the hashring is reachable at the given address
+func (t *ConnectionTrackerT) isInHashring(address string) bool {
+	snapshot := t.hashring.Snapshot()
+	for _, server := range snapshot.GetServerList() {
+		if snapshot.GetServerAddress(server) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// delete forgets the given address and its gauges. It must be called with the lock held
+func (t *ConnectionTrackerT) delete(address string) {
+	labels := t.getLabels(address)
+	t.meter.BackendOpenConnections.Delete(labels)
+	t.meter.BackendIdleConnections.Delete(labels)
+	delete(t.connections, address)
+}
+
+// getLabels returns the labels of the gauges of the given address
+func (t *ConnectionTrackerT) getLabels(address string) prometheus.Labels {
+	return prometheus.Labels{
+		"proxy_name": t.labels["proxy_name"],
+		"route":      t.labels["route"],
+		"backend":    address,
+	}
+}
+
+// setIdle updates the idle state of the connection, reporting only the actual changes
+func (c *trackedConnT) setIdle(idle bool) {
+	if c.idle.CompareAndSwap(!idle, idle) {
+		if idle {
+			c.idleGauge.Inc()
+			return
+		}
+		c.idleGauge.Dec()
+	}
+}
+
+func (c *trackedConnT) Close() error {
+	c.closeOnce.Do(func() {
+		c.setIdle(false)
+		c.openGauge.Dec()
+		c.tracker.release(c.address)
+	})
+	return c.Conn.Close()
+}
+
+// getTrackedDialContext returns a dial function wrapping the connections of the given dialer,
+// so they are reported in the connection gauges of the route
+func getTrackedDialContext(route *RouteT, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return route.Connections.track(conn, addr), nil
+	}
+}
+
+// getConnectionTrace returns a trace reporting when the connection used by a request
+// leaves or comes back to the pool of idle connections. HTTP/2 transports do not call these hooks,
+// so their connections are never reported as idle.
+// It must be used by a single request
+func getConnectionTrace() *httptrace.ClientTrace {
+
+	var conn *trackedConnT
+
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			conn, _ = info.Conn.(*trackedConnT)
+			if conn != nil {
+				conn.setIdle(false)
+			}
+		},
+		PutIdleConn: func(err error) {
+			if err == nil && conn != nil {
+				conn.setIdle(true)
+			}
+		},
+	}
+}